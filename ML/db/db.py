@@ -2,6 +2,7 @@ import os
 import json
 import math
 import uuid
+from datetime import date
 import psycopg2
 import requests
 from psycopg2.extras import RealDictCursor
@@ -241,18 +242,42 @@ class SupabaseClient:
         try:
             cur = conn.cursor()
             
+            if qty <= 0 or not donor_id or donor_id == requestor_id:
+                return "Error saving card: need a positive quantity and two different facilities"
+
+            # Schema version 2 (see CardPayload in backend/models/card_payload.go):
+            # flat keys with the item's ID so the backend can execute the card
+            cur.execute(
+                "SELECT id, name FROM items WHERE name ILIKE %s OR generic_name ILIKE %s LIMIT 1",
+                (item, item)
+            )
+            item_row = cur.fetchone()
+            if item_row is None:
+                return f"Error saving card: unknown item '{item}'"
+            cur.execute("SELECT id, name FROM facilities WHERE id IN (%s, %s)", (donor_id, requestor_id))
+            names = {r['id']: r['name'] for r in cur.fetchall()}
+            unknown = [f for f in (donor_id, requestor_id) if f not in names]
+            if unknown:
+                return f"Error saving card: unknown facility '{unknown[0]}'"
+
             payload_data = {
-                "request_details": {
-                    "requestor_phc": requestor_id,
-                    "quantity_needed": qty
-                },
-                "recommendation": {
-                    "quantity": qty,
-                    "logistics": logistics_data
-                }
+                "schema_version": 2,
+                "source_facility_id": donor_id,
+                "source_facility_name": names[donor_id],
+                "destination_facility_id": requestor_id,
+                "destination_facility_name": names[requestor_id],
+                "item_id": item_row['id'],
+                "item_name": item_row['name'],
+                "quantity": qty,
+                "logistics": logistics_data
             }
             
-            idempotency_token = str(uuid.uuid4())
+            # Same recommendation on the same day -> same token, so agent
+            # retries cannot create duplicate cards (unique index on the column)
+            idempotency_token = str(uuid.uuid5(
+                uuid.NAMESPACE_URL,
+                f"solution-card:{requestor_id}:{donor_id}:{item}:{qty}:{date.today().isoformat()}"
+            ))
             sql = """
             INSERT INTO solution_cards (
                 status, 
@@ -266,6 +291,7 @@ class SupabaseClient:
                 confidence_score
             ) 
             VALUES (%s, %s, 7, %s, %s, %s, %s, 'AI', 95) 
+            ON CONFLICT DO NOTHING
             RETURNING id
             """
             
@@ -278,7 +304,12 @@ class SupabaseClient:
                 idempotency_token
             ))
             
-            new_id = cur.fetchone()['id']
+            row = cur.fetchone()
+            if row is None:
+                # Duplicate: return the card that already exists
+                cur.execute("SELECT id FROM solution_cards WHERE idempotency_token = %s", (idempotency_token,))
+                row = cur.fetchone()
+            new_id = row['id']
             conn.commit()
             print(f"--- 💾 SOLUTION CARD SAVED: {new_id} ---")
             return str(new_id)
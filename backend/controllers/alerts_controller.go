@@ -3,6 +3,7 @@ package controllers
 import (
 	"backend/db"
 	"backend/models"
+	"backend/services"
 	"fmt"
 	"math/rand"
 	"net/http"
@@ -24,64 +25,28 @@ func GetAlertsFeed(c *gin.Context) {
 		return
 	}
 
-	// 2. Fetch Facility Map (ID -> Name) for quick lookup
-	// This ensures we show "Primary Health Centre" instead of "fac_thane_01"
-	facilityMap := make(map[string]string)
-	var facilities []models.Facility
-	if err := db.Select("id, name").Find(&facilities).Error; err == nil {
-		for _, f := range facilities {
-			facilityMap[f.ID] = f.Name
-		}
-	}
+	// 2. Normalize the payloads the way the detail view does, one lookup per
+	// table for the whole feed
+	payloads := services.NormalizeCardPayloads(db, cards)
 
 	var uiResponse []map[string]interface{}
 
-	for _, card := range cards {
-		payload := card.Payload
-		if payload == nil {
-			payload = make(models.JSONMap)
-		}
+	for i, card := range cards {
+		payload := payloads[i]
 
-		// --- SMART PARSER START ---
-		// Handle both "Flat" and "Nested" payload structures from the CSV data
-		
 		// A. Resolve Medicine Name
 		medicine := "Unknown Medicine"
-		if v, ok := payload["item_name"].(string); ok {
-			medicine = v
-		} else if req, ok := payload["request_details"].(map[string]interface{}); ok {
-			if v, ok := req["item_requested"].(string); ok {
-				medicine = v
-			}
+		if payload.ItemName != "" {
+			medicine = payload.ItemName
 		}
 
 		// B. Resolve At-Risk PHC Name (Destination/Requestor)
 		phcName := "Unknown PHC"
-		var phcID string
-
-		// Try finding explicit name first
-		if v, ok := payload["destination_facility_name"].(string); ok {
-			phcName = v
-		} else if v, ok := payload["source_facility_name"].(string); ok {
-			// Fallback: sometimes source is the context
-			phcName = v
-		} else {
-			// Try finding IDs and looking them up
-			if v, ok := payload["destination_facility_id"].(string); ok {
-				phcID = v
-			} else if req, ok := payload["request_details"].(map[string]interface{}); ok {
-				if v, ok := req["requestor_phc"].(string); ok {
-					phcID = v
-				}
-			}
-			// Lookup Name from ID
-			if name, exists := facilityMap[phcID]; exists {
-				phcName = name
-			} else if phcID != "" {
-				phcName = phcID // Show ID if name not found
-			}
+		if payload.DestinationFacilityName != "" {
+			phcName = payload.DestinationFacilityName
+		} else if payload.DestinationFacilityID != "" {
+			phcName = payload.DestinationFacilityID // Show ID if name not found
 		}
-		// --- SMART PARSER END ---
 
 		// 3. Determine Urgency from Priority Score
 		urgency := "Medium"
@@ -146,29 +111,20 @@ func GetAlertDetails(c *gin.Context) {
 		return
 	}
 
-	// --- SMART PARSER (Same as Feed) ---
-	payload := card.Payload
-	if payload == nil {
-		payload = make(models.JSONMap)
-	}
+	payload := services.NormalizeCardPayload(db, &card)
 
 	// 1. Resolve Medicine
 	medicine := "Unknown Medicine"
-	if v, ok := payload["item_name"].(string); ok {
-		medicine = v
-	} else if req, ok := payload["request_details"].(map[string]interface{}); ok {
-		if v, ok := req["item_requested"].(string); ok {
-			medicine = v
-		}
+	if payload.ItemName != "" {
+		medicine = payload.ItemName
 	}
 
 	// 2. Resolve PHC
 	phcName := "Unknown PHC"
-	// (Simplified lookup for single item - ideally reuse map if cached, or just show raw string for speed)
-	if v, ok := payload["destination_facility_name"].(string); ok {
-		phcName = v
-	} else if v, ok := payload["source_facility_name"].(string); ok {
-		phcName = v
+	if payload.DestinationFacilityName != "" {
+		phcName = payload.DestinationFacilityName
+	} else if payload.DestinationFacilityID != "" {
+		phcName = payload.DestinationFacilityID
 	}
 
 	// 3. Urgency
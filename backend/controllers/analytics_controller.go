@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"backend/db"
+	"backend/models"
 	"net/http"
 	// "time"
 
@@ -65,7 +66,7 @@ func GetInventoryEfficiency(c *gin.Context) {
 	type FacilityPoint struct {
 		FacilityName string  `json:"facility_name"`
 		PatientLoad  int     `json:"patient_load"`
-		StockLevel   int     `json:"stock_level"` // Total items
+		StockLevel   int     `json:"stock_level"` // Total unreserved items
 		Status       string  `json:"status"`      // For coloring dots
 	}
 	
@@ -75,7 +76,7 @@ func GetInventoryEfficiency(c *gin.Context) {
 		SELECT 
 			f.name as facility_name,
 			(SELECT COUNT(*) FROM admission_logs a WHERE a.facility_id = f.id AND a.admission_date > NOW() - INTERVAL '30 days') as patient_load,
-			(SELECT SUM(quantity) FROM inventories i WHERE i.facility_id = f.id) as stock_level,
+			(SELECT SUM(quantity - reserved_quantity) FROM inventories i WHERE i.facility_id = f.id) as stock_level,
 			-- Determine broad status based on avg inventory status
 			COALESCE(
 				(SELECT status FROM inventories inv WHERE inv.facility_id = f.id ORDER BY CASE status WHEN 'Critical' THEN 1 WHEN 'Watchlist' THEN 2 ELSE 3 END LIMIT 1),
@@ -116,11 +117,11 @@ func GetRobinHoodMetric(c *gin.Context) {
 			SUM(t.quantity) as quantity
 		FROM transfers t
 		JOIN facilities f ON t.from_facility_id = f.id
-		WHERE t.status = 'DELIVERED'
+		WHERE t.status = ?
 		GROUP BY 1
 	`
 	
-	if err := db.Raw(query).Scan(&stats).Error; err != nil {
+	if err := db.Raw(query, models.TransferDelivered).Scan(&stats).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch robin hood stats"})
 		return
 	}
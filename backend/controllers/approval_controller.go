@@ -2,7 +2,9 @@ package controllers
 
 import (
 	"backend/db"
+	"backend/events"
 	"backend/models"
+	"backend/services"
 	"fmt"
 	"net/http"
 
@@ -15,17 +17,24 @@ func GetApprovalQueue(c *gin.Context) {
 
 	// Get User Context from JWT
 	role, _ := c.Get("role")
-	// district, _ := c.Get("district") // Optional: Filter by district for DHO
+	district := getContextString(c, "district", "Mumbai_City")
 	facilityID, _ := c.Get("facility_id")
 
-	query := db.Where("status = ?", "pending").Order("priority_score DESC")
-
-	// IF PHC Staff -> Only show cards relevant to MY facility
-	if role == "PHC_Staff" || role == "PHC" {
-		// Filter where source OR destination is my facility
-		// Note: We use the raw JSONB field or columns if mapped
-		// Since we added FromFacilityID column mapping in models, we can use that:
-		query = query.Where("from_facilityid = ? OR to_facilityid = ?", facilityID, facilityID)
+	query := db.Where("status = ?", models.CardPending).Order("priority_score DESC")
+
+	// Each role only sees the cards waiting for its own step
+	var step string
+	switch role {
+	case "PHC_Staff", "PHC":
+		// Donor staff: cards asking MY facility to give
+		query = query.Where("from_facilityid = ?", facilityID)
+		step = services.StepDonorConsent
+	case services.RoleDHO:
+		query = query.Where("from_facilityid IS NULL OR from_facilityid IN (SELECT id FROM facilities WHERE district = ?)", district)
+		step = services.StepDHO
+	default:
+		c.JSON(http.StatusForbidden, gin.H{"error": "No approval step for this role"})
+		return
 	}
 
 	if err := query.Find(&cards).Error; err != nil {
@@ -33,7 +42,7 @@ func GetApprovalQueue(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, cards)
+	c.JSON(http.StatusOK, services.CanonicalPayloads(db, services.FilterByStep(db, cards, step)))
 }
 
 // HandleApprovalAction processes the decision
@@ -42,76 +51,111 @@ func HandleApprovalAction(c *gin.Context) {
 	id := c.Param("id")
 	
 	var input struct {
-		Action string `json:"action"` 
+		Action   string      `json:"action"` 
+		Quantity interface{} `json:"quantity"` // Optional partial approval
+		Reason   string      `json:"reason"`   // Required when donor staff decline
 	}
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON input"})
 		return
 	}
-
+	qty, ok := parseQuantity(input.Quantity)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "quantity must be a whole number"})
+		return
+	}
+	actorID := getContextString(c, "user_id", "")
+	role := getContextString(c, "role", "")
+
+	// DHO: same service as /solutions/:id/approve so both UIs behave identically.
+	// Donor staff: approve/reject is their consent step.
+	var donorStep bool
+	switch role {
+	case services.RoleDHO:
+	case "PHC_Staff", "PHC":
+		donorStep = true
+	default:
+		c.JSON(http.StatusForbidden, gin.H{"error": "No approval step for this role"})
+		return
+	}
+	district := getDistrictScope(c)
+	var result *services.ApprovalResult
 	err := db.Transaction(func(tx *gorm.DB) error {
-		var card models.SolutionCard
-		if err := tx.First(&card, "id = ?", id).Error; err != nil {
-			return fmt.Errorf("card not found: %w", err)
+		var err error
+		switch {
+		case input.Action != "approve" && input.Action != "reject":
+			err = fmt.Errorf("%w: unknown action %q", services.ErrInvalidInput, input.Action)
+		case donorStep:
+			result, err = services.RespondAsDonor(tx, id, services.ConsentInput{
+				ActorID:    actorID,
+				FacilityID: getContextString(c, "facility_id", ""),
+				Consent:    input.Action == "approve",
+				Reason:     input.Reason,
+			})
+		case input.Action == "approve":
+			result, err = services.ApproveCard(tx, id, services.ApprovalInput{ActorID: actorID, Role: role, District: district, Quantity: qty})
+		default:
+			result, err = services.RejectCard(tx, id, services.RejectionInput{ActorID: actorID, Role: role, District: district})
 		}
+		return err
+	})
 
-		if input.Action == "approve" {
-			card.Status = "approved"
-			
-			payload := card.Payload
-			if payload == nil {
-				return fmt.Errorf("cannot approve card with empty payload")
-			}
-			
-			getString := func(k string) string {
-				if v, ok := payload[k].(string); ok { return v }
-				return ""
-			}
-			getFloat := func(k string) int {
-				if v, ok := payload[k].(float64); ok { return int(v) }
-				if v, ok := payload[k].(int); ok { return v }
-				return 0
-			}
-
-			vehicle := getString("transport_mode")
-			if vehicle == "" { vehicle = "VAN" }
-
-			newTransfer := models.Transfer{
-				SolutionCardID: &card.ID,
-				FromFacilityID: getString("source_facility_id"),
-				ToFacilityID:   getString("destination_facility_id"),
-				ItemID:         getString("item_id"),
-				Quantity:       getFloat("quantity"),
-				Status:         "PENDING", 
-				VehicleType:    vehicle,
-			}
-			
-			if newTransfer.FromFacilityID == "" || newTransfer.ToFacilityID == "" || newTransfer.ItemID == "" {
-				// Fallback to columns if payload empty
-				// This handles cases where payload structure varies
-				if card.FromFacilityID != nil { newTransfer.FromFacilityID = *card.FromFacilityID }
-				if card.ToFacilityID != nil { newTransfer.ToFacilityID = *card.ToFacilityID }
-			}
-
-			if err := tx.Omit("ID").Create(&newTransfer).Error; err != nil {
-				return fmt.Errorf("failed to create transfer: %w", err)
-			}
-		} else {
-			card.Status = "rejected"
-		}
+	if err != nil {
+		respondServiceError(c, err)
+		return
+	}
+	switch {
+	case input.Action == "reject":
+		events.PublishCard(db, events.CardRejected, id)
+	case donorStep:
+		events.PublishCard(db, events.CardConsented, id)
+	default:
+		events.PublishCard(db, events.CardApproved, id)
+		events.PublishTransferByID(db, result.TransferID)
+	}
 
-		if err := tx.Save(&card).Error; err != nil {
-			return fmt.Errorf("failed to update card status: %w", err)
-		}
+	c.JSON(http.StatusOK, result)
+}
 
-		return nil
-	})
+// HandleBulkApprovalAction approves or rejects several cards; each card commits on its own
+func HandleBulkApprovalAction(c *gin.Context) {
+	db := db.GetDB()
+
+	var input struct {
+		CardIDs []string `json:"card_ids" binding:"required"`
+		Action  string   `json:"action" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "card_ids and action are required"})
+		return
+	}
 
+	result, err := services.BulkDecide(db, input.CardIDs, input.Action, services.ApprovalInput{
+		ActorID:  getContextString(c, "user_id", ""),
+		Role:     getContextString(c, "role", ""),
+		District: getDistrictScope(c),
+	})
 	if err != nil {
-		fmt.Println("Transaction Error:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondServiceError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Action processed successfully"})
-}
\ No newline at end of file
+	for _, r := range result.Results {
+		if r.Result != services.OutcomeSuccess {
+			continue
+		}
+		if input.Action == services.BulkApprove {
+			events.PublishCard(db, events.CardApproved, r.CardID)
+			events.PublishTransferByID(db, r.Detail.TransferID)
+		} else {
+			events.PublishCard(db, events.CardRejected, r.CardID)
+		}
+	}
+
+	// 207: outcomes differ per card
+	status := http.StatusOK
+	if result.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, result)
+}
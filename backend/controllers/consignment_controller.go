@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"backend/db"
+	"backend/events"
+	"backend/models"
+	"backend/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetConsignment returns a consignment with its lines and the trip's history
+func GetConsignment(c *gin.Context) {
+	db := db.GetDB()
+
+	consignment, err := services.LoadConsignment(db, c.Param("id"))
+	if err != nil {
+		respondServiceError(c, err)
+		return
+	}
+	if len(consignment.Lines) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Consignment has no lines"})
+		return
+	}
+	if !canSeeTransfer(c, db, consignment.Lines[0].ID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Consignment not found"})
+		return
+	}
+
+	// Every line records the same step, so the first line's history is the trip's
+	var events []models.TransferEvent
+	db.Where("transfer_id = ?", consignment.Lines[0].ID).Order("created_at ASC").Find(&events)
+
+	lateBy := consignment.Lines[0].LateBy(time.Now())
+	c.JSON(http.StatusOK, gin.H{
+		"consignment":    consignment,
+		"total_quantity": consignment.TotalQuantity(),
+		"events":         events,
+		"late":           lateBy > 0,
+		"late_minutes":   int(lateBy.Minutes()),
+	})
+}
+
+// ConfirmConsignmentDelivery: receiving PHC records what arrived, line by line
+func ConfirmConsignmentDelivery(c *gin.Context) {
+	db := db.GetDB()
+
+	var input struct {
+		Lines     []services.ConsignmentReceivedLine `json:"lines" binding:"required"`
+		Condition string                             `json:"condition"`
+		Note      string                             `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Received quantities required"})
+		return
+	}
+
+	id := c.Param("id")
+	var reports []*services.DeliveryReport
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		reports, err = services.ConfirmConsignmentDelivery(tx, id, input.Lines, services.DeliveryConfirmation{
+			ActorID:    getContextString(c, "user_id", ""),
+			FacilityID: getContextString(c, "facility_id", ""),
+			Condition:  input.Condition,
+			Note:       input.Note,
+		})
+		return err
+	})
+	if err != nil {
+		respondServiceError(c, err)
+		return
+	}
+	events.PublishConsignment(db, id)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"reports": reports,
+	})
+}
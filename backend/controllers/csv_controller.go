@@ -2,12 +2,15 @@ package controllers
 
 import (
 	"backend/db"
+	"backend/events"
 	"backend/models"
+	"backend/services"
 	"encoding/csv"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -148,3 +151,113 @@ func ImportAdmissions(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Uploaded %d patient records", successCount)})
 }
+
+// UploadTemperatureLog: Parses a temperature logger CSV -> Stores readings
+// against the transfer -> Flags excursions. Header names the columns:
+// timestamp (or recorded_at/time), temperature (or temp_c/temperature_c)
+// and optionally logger_id (or serial).
+func UploadTemperatureLog(c *gin.Context) {
+	file, _, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File required"})
+		return
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Empty or invalid CSV"})
+		return
+	}
+	col := map[string]int{}
+	for i, name := range header {
+		col[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	timeCol, tempCol, loggerCol := pickColumn(col, "timestamp", "recorded_at", "time"), pickColumn(col, "temperature", "temp_c", "temperature_c"), pickColumn(col, "logger_id", "serial")
+	if timeCol < 0 || tempCol < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "CSV needs timestamp and temperature columns"})
+		return
+	}
+
+	var readings []services.ReadingInput
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil || len(record) <= timeCol || len(record) <= tempCol {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Line %d: malformed row", line)})
+			return
+		}
+		at, ok := parseReadingTime(strings.TrimSpace(record[timeCol]))
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Line %d: unreadable timestamp %q", line, record[timeCol])})
+			return
+		}
+		temp, err := strconv.ParseFloat(strings.TrimSpace(record[tempCol]), 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Line %d: unreadable temperature %q", line, record[tempCol])})
+			return
+		}
+		r := services.ReadingInput{RecordedAt: at, TempC: temp}
+		if loggerCol >= 0 && loggerCol < len(record) {
+			r.LoggerID = record[loggerCol]
+		}
+		readings = append(readings, r)
+	}
+
+	db := db.GetDB()
+	var report *services.TemperatureReport
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		report, err = services.RecordTemperatureLog(tx, c.Param("id"), readings, services.TemperatureUpload{
+			ActorID:    getContextString(c, "user_id", ""),
+			Role:       getContextString(c, "role", ""),
+			FacilityID: getContextString(c, "facility_id", ""),
+		})
+		return err
+	})
+	if err != nil {
+		respondServiceError(c, err)
+		return
+	}
+	for _, id := range report.Flagged {
+		events.PublishTransferByID(db, id)
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// GetTemperatureLog: Readings uploaded for a transfer's trip
+func GetTemperatureLog(c *gin.Context) {
+	readings, err := services.TemperatureLog(db.GetDB(), c.Param("id"))
+	if err != nil {
+		respondServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"readings": readings})
+}
+
+func pickColumn(col map[string]int, names ...string) int {
+	for _, n := range names {
+		if i, ok := col[n]; ok {
+			return i
+		}
+	}
+	return -1
+}
+
+// Logger exports differ; accept RFC3339 or plain local date-times
+func parseReadingTime(s string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, true
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
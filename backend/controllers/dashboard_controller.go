@@ -5,6 +5,7 @@ import (
 	"backend/models"
 	"net/http"
 	"fmt"
+	"strings"
 	"time"
 	"github.com/gin-gonic/gin"
 )
@@ -83,7 +84,7 @@ func GetDashboardStats(c *gin.Context) {
 	db.Model(&models.Transfer{}).
 		Joins("JOIN facilities f1 ON transfers.from_facility_id = f1.id").
 		Joins("JOIN facilities f2 ON transfers.to_facility_id = f2.id").
-		Where("(f1.district = ? OR f2.district = ?) AND transfers.status = ?", userDistrict, userDistrict, "IN_TRANSIT").
+		Where("(f1.district = ? OR f2.district = ?) AND transfers.status IN ?", userDistrict, userDistrict, models.ActiveTransferStatuses).
 		Count(&stats.ActiveTransfers)
 
 	// 3. Network Health Calculation
@@ -153,23 +154,26 @@ func GetRecentActivity(c *gin.Context) {
 	// We select raw columns and scan them into the struct or map
 	// This query joins transfers -> items, facilities (from), facilities (to)
 	var rawResults []struct {
-		ID        string
-		UpdatedAt time.Time
-		Status    string
-		ItemName  string
-		Quantity  int
-		ToName    string
-		FromName  string
+		ID                   string
+		ConsignmentID        *string
+		UpdatedAt            time.Time
+		Status               string
+		ItemName             string
+		Quantity             int
+		ToName               string
+		FromName             string
+		EstimatedArrivalTime *time.Time
+		ActualDeliveryTime   *time.Time
 	}
 
 	err := db.Table("transfers").
-		Select("transfers.id, transfers.updated_at, transfers.status, i.name as item_name, transfers.quantity, f1.name as from_name, f2.name as to_name").
+		Select("transfers.id, transfers.consignment_id, transfers.updated_at, transfers.status, i.name as item_name, transfers.quantity, f1.name as from_name, f2.name as to_name, transfers.estimated_arrival_time, transfers.actual_delivery_time").
 		Joins("JOIN items i ON transfers.item_id = i.id").
 		Joins("JOIN facilities f1 ON transfers.from_facility_id = f1.id").
 		Joins("JOIN facilities f2 ON transfers.to_facility_id = f2.id").
 		Where("f1.district = ? OR f2.district = ?", userDistrict, userDistrict).
 		Order("transfers.updated_at DESC").
-		Limit(10).
+		Limit(50). // Consignment lines fold into one entry below
 		Scan(&rawResults).Error
 
 	if err != nil {
@@ -180,27 +184,76 @@ func GetRecentActivity(c *gin.Context) {
 	// 3. Format the Output
 	var formattedFeed []map[string]interface{}
 
+	// A consignment is one trip: its lines share an entry listing every item
+	type activityItem struct {
+		ItemName string `json:"item_name"`
+		Quantity int    `json:"quantity"`
+	}
+	tripItems := map[string][]activityItem{}
+	tripOrder := []int{}
+	for i, t := range rawResults {
+		key := t.ID
+		if t.ConsignmentID != nil {
+			key = *t.ConsignmentID
+		}
+		if _, seen := tripItems[key]; !seen {
+			tripOrder = append(tripOrder, i)
+		}
+		tripItems[key] = append(tripItems[key], activityItem{t.ItemName, t.Quantity})
+	}
+	if len(tripOrder) > 10 {
+		tripOrder = tripOrder[:10]
+	}
+
 	// If we have no real transfers, the list will be empty (which is correct for "Real Data only")
-	for _, t := range rawResults {
+	for _, i := range tripOrder {
+		t := rawResults[i]
+		id := t.ID
+		if t.ConsignmentID != nil {
+			id = *t.ConsignmentID
+		}
+		parts := []string{}
+		for _, it := range tripItems[id] {
+			parts = append(parts, fmt.Sprintf("%d %s", it.Quantity, it.ItemName))
+		}
+		what := strings.Join(parts, ", ")
+
 		msg := ""
 		icon := "transfer"
 		
 		switch t.Status {
-		case "PENDING":
-			msg = fmt.Sprintf("Request: %d %s from %s", t.Quantity, t.ItemName, t.FromName)
-		case "IN_TRANSIT":
-			msg = fmt.Sprintf("Dispatched: %d %s to %s", t.Quantity, t.ItemName, t.ToName)
+		case models.TransferPending, models.TransferApproved:
+			msg = fmt.Sprintf("Request: %s from %s", what, t.FromName)
+		case models.TransferAwaitingDriver:
+			msg = fmt.Sprintf("Awaiting driver: %s from %s", what, t.FromName)
+			icon = "alert"
+		case models.TransferPickedUp, models.TransferInTransit:
+			msg = fmt.Sprintf("Dispatched: %s to %s", what, t.ToName)
 			icon = "truck"
-		case "DELIVERED":
-			msg = fmt.Sprintf("Delivered: %d %s at %s", t.Quantity, t.ItemName, t.ToName)
+		case models.TransferDelivered:
+			msg = fmt.Sprintf("Delivered: %s at %s", what, t.ToName)
 			icon = "check"
+		case models.TransferCancelled, models.TransferFailed:
+			msg = fmt.Sprintf("Stopped: %s to %s (%s)", what, t.ToName, t.Status)
+			icon = "alert"
 		}
 
+		lateBy := models.Transfer{
+			Status:               t.Status,
+			EstimatedArrivalTime: t.EstimatedArrivalTime,
+			ActualDeliveryTime:   t.ActualDeliveryTime,
+		}.LateBy(time.Now())
+
 		formattedFeed = append(formattedFeed, map[string]interface{}{
-			"id":        t.ID,
-			"timestamp": t.UpdatedAt, // Go's JSON marshaller handles time format automatically
-			"message":   msg,
-			"type":      icon,
+			"id":           id,
+			"consignment":  t.ConsignmentID != nil,
+			"items":        tripItems[id],
+			"timestamp":    t.UpdatedAt, // Go's JSON marshaller handles time format automatically
+			"message":      msg,
+			"type":         icon,
+			"eta":          t.EstimatedArrivalTime,
+			"late":         lateBy > 0,
+			"late_minutes": int(lateBy.Minutes()),
 		})
 	}
 
@@ -231,9 +284,9 @@ func GetRegionalSummary(c *gin.Context) {
 		Where("id IN (?)", db.Model(&models.Inventory{}).Select("facility_id").Where("status = ?", "Critical")).
 		Count(&summary.RedPHCs)
 
-	// 3. Inventory Value
+	// 3. Inventory Value (unreserved stock only)
 	db.Raw(`
-		SELECT COALESCE(SUM(inv.quantity * i.unit_cost), 0)
+		SELECT COALESCE(SUM((inv.quantity - inv.reserved_quantity) * i.unit_cost), 0)
 		FROM inventories inv
 		JOIN items i ON inv.item_id = i.id
 		JOIN facilities f ON inv.facility_id = f.id
@@ -263,7 +316,7 @@ func GetRegionalSummary(c *gin.Context) {
 
 	// Map status to UI tags (IN_TRANSIT -> pending, DELIVERED -> completed)
 	for i, t := range transfers {
-		if t.Status == "DELIVERED" {
+		if t.Status == models.TransferDelivered {
 			transfers[i].Status = "completed"
 		} else {
 			transfers[i].Status = "pending"
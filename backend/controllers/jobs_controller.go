@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"backend/db"
+	"backend/models"
+	"backend/services"
+	"backend/workers"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jobScope works out whose jobs the caller is looking at. A DHO sees the
+// global jobs and their own district's; an administrator sees everything
+// and names a district with ?district= where one is needed (empty means
+// none given). Anyone else gets a 403.
+func jobScope(c *gin.Context) (district string, admin bool, ok bool) {
+	switch getContextString(c, "role", "") {
+	case services.RoleAdmin:
+		return c.Query("district"), true, true
+	case services.RoleDHO:
+		if district := getDistrictScope(c); district != "" {
+			return district, false, true
+		}
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "Only the DHO or an administrator can manage scheduled jobs"})
+	return "", false, false
+}
+
+// jobDistrict is the district a view of a job is about; per-district jobs
+// without one show the GLOBAL defaults.
+func jobDistrict(job workers.Job, district string) string {
+	if job.PerDistrict && district != "" {
+		return district
+	}
+	return workers.GlobalDistrict
+}
+
+// GetJobs lists the scheduled jobs with their settings and last run for
+// the caller's district.
+func GetJobs(c *gin.Context) {
+	district, _, ok := jobScope(c)
+	if !ok {
+		return
+	}
+	db := db.GetDB()
+
+	jobs := []gin.H{}
+	for _, job := range workers.Jobs {
+		d := jobDistrict(job, district)
+		jobs = append(jobs, gin.H{
+			"name":             job.Name,
+			"per_district":     job.PerDistrict,
+			"district":         d,
+			"enabled":          job.Enabled(db, d),
+			"interval_minutes": int(job.Interval(db, d).Minutes()),
+			"last_run":         services.LastJobRun(db, job.Name, d),
+		})
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+// GetJobRuns returns run history for the caller's district and the global
+// jobs (every district for an administrator without ?district=), newest
+// first. Optional ?job= and ?limit= (default 50, max 200).
+func GetJobRuns(c *gin.Context) {
+	district, admin, ok := jobScope(c)
+	if !ok {
+		return
+	}
+	db := db.GetDB()
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	query := db.Model(&models.JobRun{})
+	if !admin || district != "" {
+		query = query.Where("district IN ?", []string{district, workers.GlobalDistrict})
+	}
+	if job := c.Query("job"); job != "" {
+		query = query.Where("job = ?", job)
+	}
+
+	var runs []models.JobRun
+	if err := query.Order("started_at DESC").Limit(limit).Find(&runs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job runs"})
+		return
+	}
+	c.JSON(http.StatusOK, runs)
+}
+
+// TriggerJob runs a job now and returns the finished run. Disabled jobs can
+// still be run by hand. A DHO runs per-district jobs for their own district;
+// an administrator runs any job, naming the district of a per-district job
+// with ?district=. Global jobs touch every district, so only an
+// administrator can trigger them.
+func TriggerJob(c *gin.Context) {
+	job, ok := workers.FindJob(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown job"})
+		return
+	}
+	district, admin, ok := jobScope(c)
+	if !ok {
+		return
+	}
+	db := db.GetDB()
+
+	switch {
+	case !job.PerDistrict && !admin:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only an administrator can run a job that covers every district"})
+		return
+	case job.PerDistrict && admin:
+		var n int64
+		db.Model(&models.Facility{}).Where("district = ?", district).Count(&n)
+		if district == "" || n == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Name a known district with ?district="})
+			return
+		}
+	}
+
+	run, err := workers.RunJob(db, job, jobDistrict(job, district), workers.TriggerManual, getContextString(c, "user_id", ""))
+	if err != nil {
+		respondServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, run)
+}
@@ -3,8 +3,10 @@ package controllers
 import (
 	"backend/db"
 	"backend/models"
+	"backend/services"
+	"fmt"
 	"net/http"
-	// "time"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -70,9 +72,18 @@ func GetMapData(c *gin.Context) {
 
 	facilityCoords := make(map[string][]float64)
 
+	ids := make([]string, 0, len(facilities))
+	for _, f := range facilities {
+		ids = append(ids, f.ID)
+	}
+	realCoords := services.FacilityCoords(db, ids...)
+
 	for i, f := range facilities {
 		// Mock logic: Assign a coord from the list based on index loop
 		coords := mockCoords[i % 9]
+		if rc, ok := realCoords[f.ID]; ok {
+			coords = []float64{rc.Lat, rc.Lng}
+		}
 		
 		// Instant Lookup from our pre-calculated map
 		status := "healthy"
@@ -92,36 +103,114 @@ func GetMapData(c *gin.Context) {
 	// 4. Fetch Active Transfers (In Transit)
 	var transfers []models.Transfer
 	if err := db.Preload("FromFacility").Preload("ToFacility").Preload("Item").
-		Where("status IN ?", []string{"IN_TRANSIT", "PENDING"}).
+		Where("status IN ?", []string{models.TransferApproved, models.TransferPickedUp, models.TransferInTransit}).
 		Find(&transfers).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transfers"})
 		return
 	}
 
-	// 5. Construct Transfer Edges
+	// 5. Latest driver position per transfer
+	transferIDs := make([]string, 0, len(transfers))
+	for _, t := range transfers {
+		transferIDs = append(transferIDs, t.ID)
+	}
+	latest := services.LatestPings(db, transferIDs)
+
+	// Hub-routed transfers are drawn on the leg they are on
+	type legPosition struct {
+		Leg   models.TransferLeg
+		Count int
+	}
+	currentLegs := map[string]*legPosition{}
+	if len(transferIDs) > 0 {
+		var legs []models.TransferLeg
+		db.Where("transfer_id IN ?", transferIDs).Order("transfer_id, sequence").Find(&legs)
+		for _, l := range legs {
+			pos, ok := currentLegs[l.TransferID]
+			if !ok {
+				pos = &legPosition{}
+				currentLegs[l.TransferID] = pos
+			}
+			pos.Count++
+			if pos.Leg.ID == "" && l.Status != models.TransferDelivered {
+				pos.Leg = l
+			}
+		}
+	}
+	facilityNames := make(map[string]string, len(facilities))
+	for _, f := range facilities {
+		facilityNames[f.ID] = f.Name
+	}
+
+	// 6. Construct Transfer Edges (one per trip: a consignment's lines share an edge)
+	type EdgeItem struct {
+		TransferID string `json:"transferId"`
+		ItemName   string `json:"itemName"`
+		Quantity   int    `json:"quantity"`
+	}
 	type TransferEdge struct {
-		ID       string    `json:"id"`
-		From     string    `json:"fromName"`
-		To       string    `json:"toName"`
-		FromLoc  []float64 `json:"from"`
-		ToLoc    []float64 `json:"to"`
-		Progress []float64 `json:"progress"` // Init at start
+		ID            string     `json:"id"`
+		ConsignmentID *string    `json:"consignmentId,omitempty"`
+		From          string     `json:"fromName"`
+		To            string     `json:"toName"`
+		FromLoc       []float64  `json:"from"`
+		ToLoc         []float64  `json:"to"`
+		Progress      []float64  `json:"progress"` // Driver's last GPS fix; the origin until the first ping
+		ProgressPct   float64    `json:"progressPct"`
+		ETA           *time.Time `json:"eta"`
+		Late          bool       `json:"late"`
+		Items         []EdgeItem `json:"items"`
+		Leg           string     `json:"leg,omitempty"` // "2/3" while a hub-routed transfer is on its second of three legs
 	}
 
 	var transferEdges []TransferEdge
+	edgeIndex := map[string]int{} // trip key -> position in transferEdges
 	for _, t := range transfers {
-		fromC := facilityCoords[t.FromFacilityID]
-		toC := facilityCoords[t.ToFacilityID]
+		item := EdgeItem{TransferID: t.ID, ItemName: t.Item.Name, Quantity: t.Quantity}
+		key := t.ID
+		if t.ConsignmentID != nil {
+			key = *t.ConsignmentID
+		}
+		if i, ok := edgeIndex[key]; ok {
+			transferEdges[i].Items = append(transferEdges[i].Items, item)
+			continue
+		}
+
+		fromID, toID, leg := t.FromFacilityID, t.ToFacilityID, ""
+		if pos, ok := currentLegs[t.ID]; ok && pos.Leg.ID != "" {
+			fromID, toID = pos.Leg.FromFacilityID, pos.Leg.ToFacilityID
+			leg = fmt.Sprintf("%d/%d", pos.Leg.Sequence, pos.Count)
+		}
+		fromC := facilityCoords[fromID]
+		toC := facilityCoords[toID]
 
 		// Safety check if coords missing (or failed to map)
 		if len(fromC) == 2 && len(toC) == 2 {
+			// Where the driver actually is, and the share of the route that
+			// puts behind them (0 until the first ping)
+			position, share := fromC, 0.0
+			if p, ok := latestTripPing(transfers, latest, key); ok {
+				position = []float64{p.Lat, p.Lng}
+				share = services.RouteProgress(
+					services.Coord{Lat: fromC[0], Lng: fromC[1]},
+					services.Coord{Lat: toC[0], Lng: toC[1]},
+					services.Coord{Lat: p.Lat, Lng: p.Lng})
+			}
+
+			edgeIndex[key] = len(transferEdges)
 			transferEdges = append(transferEdges, TransferEdge{
-				ID:       t.ID,
-				From:     t.FromFacility.Name,
-				To:       t.ToFacility.Name,
-				FromLoc:  fromC,
-				ToLoc:    toC,
-				Progress: fromC, // Start animation at source location
+				ID:            key,
+				ConsignmentID: t.ConsignmentID,
+				From:          facilityNames[fromID],
+				To:            facilityNames[toID],
+				FromLoc:       fromC,
+				ToLoc:         toC,
+				Progress:      position,
+				ProgressPct:   share * 100,
+				ETA:           t.EstimatedArrivalTime,
+				Late:          t.LateBy(time.Now()) > 0,
+				Items:         []EdgeItem{item},
+				Leg:           leg,
 			})
 		}
 	}
@@ -130,4 +219,20 @@ func GetMapData(c *gin.Context) {
 		"phcs":      phcNodes,
 		"transfers": transferEdges,
 	})
-}
\ No newline at end of file
+}
+
+// latestTripPing is the newest fix on any transfer of a trip, keyed by the
+// transfer ID or, for a consignment, the consignment ID.
+func latestTripPing(transfers []models.Transfer, latest map[string]models.LocationPing, key string) (models.LocationPing, bool) {
+	var best models.LocationPing
+	found := false
+	for _, t := range transfers {
+		if t.ID != key && (t.ConsignmentID == nil || *t.ConsignmentID != key) {
+			continue
+		}
+		if p, ok := latest[t.ID]; ok && (!found || p.RecordedAt.After(best.RecordedAt)) {
+			best, found = p, true
+		}
+	}
+	return best, found
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"backend/db"
+	"backend/events"
+	"backend/models"
+	"backend/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// CreateRecommendation ranks donors for a shortage without the Python agent.
+// With "persist": true the best donor is saved as a pending RULES card.
+func CreateRecommendation(c *gin.Context) {
+	db := db.GetDB()
+
+	var input struct {
+		FacilityID    string `json:"facility_id"` // Recipient; defaults to the caller's facility
+		ItemID        string `json:"item_id"`
+		ItemName      string `json:"item_name"`
+		Quantity      int    `json:"quantity" binding:"required"`
+		Limit         int    `json:"limit"`
+		Persist       bool   `json:"persist"`
+		TransportMode string `json:"transport_mode"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "quantity and item_id or item_name are required"})
+		return
+	}
+
+	// PHC staff can only ask on behalf of their own facility
+	ownFacility := getContextString(c, "facility_id", "")
+	if input.FacilityID == "" {
+		input.FacilityID = ownFacility
+	}
+	if getContextString(c, "role", "") != services.RoleDHO && input.FacilityID != ownFacility {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only request stock for your own facility"})
+		return
+	}
+
+	var rec *services.Recommendation
+	var card *models.SolutionCard
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		rec, err = services.FindDonors(tx, services.DonorQuery{
+			FacilityID: input.FacilityID,
+			ItemID:     input.ItemID,
+			ItemName:   input.ItemName,
+			Quantity:   input.Quantity,
+			Limit:      input.Limit,
+		})
+		if err != nil || !input.Persist {
+			return err
+		}
+		card, err = services.CreateRulesCard(tx, rec, input.TransportMode)
+		return err
+	})
+	if err != nil {
+		respondServiceError(c, err)
+		return
+	}
+
+	resp := gin.H{"recommendation": rec}
+	if card != nil {
+		events.PublishCard(db, events.CardCreated, card.ID)
+		resp["card"] = card
+	}
+	c.JSON(http.StatusOK, resp)
+}
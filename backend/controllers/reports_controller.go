@@ -3,6 +3,7 @@ package controllers
 import (
 	"backend/db"
 	"backend/models"
+	"math"
 	"net/http"
 	"fmt"
 	"github.com/gin-gonic/gin"
@@ -41,24 +42,34 @@ func GetStockoutPreventionTrend(c *gin.Context) {
 	c.JSON(http.StatusOK, results)
 }
 
+// tripKey identifies a trip: a consignment's lines travel together and count once.
+const tripKey = "COALESCE(transfers.consignment_id::text, transfers.id::text)"
+
+// routedTransfer matches transfers sent through hubs, timed per leg in GetLegTimes.
+const routedTransfer = "EXISTS (SELECT 1 FROM transfer_legs l WHERE l.transfer_id = transfers.id)"
+
 // 2. Transfer Time Trend (Robust Status Check)
 func GetTransferTimeTrend(c *gin.Context) {
 	db := db.GetDB()
 	district := getDistrictScope(c)
 
 	type Result struct {
-		Date string  `json:"date"`
-		Bike float64 `json:"bike"`
-		Van  float64 `json:"van"`
+		Date   string  `json:"date"`
+		Bike   float64 `json:"bike"`
+		Van    float64 `json:"van"`
+		Routed float64 `json:"routed"` // End-to-end, for transfers sent via hubs
 	}
 	var results []Result
 
-	query := db.Table("transfers").
+	// One row per trip, so a consignment's delivery time is not weighted by its line count.
+	// Hub-routed transfers change vehicle on the way, so they are not in a vehicle bucket.
+	query := db.Table("(SELECT DISTINCT ON (" + tripKey + ") * FROM transfers) AS transfers").
 		Select(`to_char(transfers.created_at, 'YYYY-MM-DD') as date,
-			COALESCE(AVG(CASE WHEN vehicle_type IN ('BIKE', 'SCOOTER') THEN EXTRACT(EPOCH FROM (actual_delivery_time - transfers.created_at))/3600 END), 0) as bike,
-			COALESCE(AVG(CASE WHEN vehicle_type IN ('VAN', 'TRUCK') THEN EXTRACT(EPOCH FROM (actual_delivery_time - transfers.created_at))/3600 END), 0) as van`).
+			COALESCE(AVG(CASE WHEN vehicle_type IN ('BIKE', 'SCOOTER') AND NOT ` + routedTransfer + ` THEN EXTRACT(EPOCH FROM (actual_delivery_time - transfers.created_at))/3600 END), 0) as bike,
+			COALESCE(AVG(CASE WHEN vehicle_type IN ('VAN', 'TRUCK') AND NOT ` + routedTransfer + ` THEN EXTRACT(EPOCH FROM (actual_delivery_time - transfers.created_at))/3600 END), 0) as van,
+			COALESCE(AVG(CASE WHEN ` + routedTransfer + ` THEN EXTRACT(EPOCH FROM (actual_delivery_time - transfers.created_at))/3600 END), 0) as routed`).
 		Joins("JOIN facilities f ON f.id = transfers.from_facility_id").
-		Where("transfers.status IN (?)", []string{"DELIVERED", "completed", "COMPLETED"}).
+		Where("transfers.status = ?", models.TransferDelivered).
 		Where("transfers.actual_delivery_time IS NOT NULL").
 		Where("transfers.created_at > NOW() - INTERVAL '60 days'")
 
@@ -70,6 +81,76 @@ func GetTransferTimeTrend(c *gin.Context) {
 	c.JSON(http.StatusOK, results)
 }
 
+// 2b. Transfer Time per Vehicle (actual fleet vehicles, not just type buckets)
+func GetVehiclePerformance(c *gin.Context) {
+	db := db.GetDB()
+	district := getDistrictScope(c)
+
+	type Result struct {
+		VehicleID    string  `json:"vehicle_id"`
+		Registration string  `json:"registration"`
+		Type         string  `json:"type"`
+		Trips        int     `json:"trips"`
+		Units        int     `json:"units"`
+		AvgHours     float64 `json:"avg_hours"`
+	}
+	var results []Result
+
+	query := db.Table("transfers").
+		Select(`v.id as vehicle_id, v.registration, v.type,
+			COUNT(DISTINCT `+tripKey+`) as trips,
+			COALESCE(SUM(transfers.quantity), 0) as units,
+			COALESCE(AVG(EXTRACT(EPOCH FROM (transfers.actual_delivery_time - transfers.created_at))/3600), 0) as avg_hours`).
+		Joins("JOIN vehicles v ON v.id = transfers.vehicle_id").
+		Joins("JOIN facilities f ON f.id = transfers.from_facility_id").
+		Where("transfers.status = ?", models.TransferDelivered).
+		Where("transfers.actual_delivery_time IS NOT NULL").
+		Where("NOT " + routedTransfer). // Several vehicles share those; see GetLegTimes
+		Where("transfers.created_at > NOW() - INTERVAL '60 days'")
+
+	if district != "" {
+		query = query.Where("f.district = ?", district)
+	}
+
+	query.Group("v.id, v.registration, v.type").Order("avg_hours ASC").Scan(&results)
+	c.JSON(http.StatusOK, results)
+}
+
+// 2c. Leg Times for hub-routed transfers: transit (pickup to handover) and
+// hub dwell (previous handover to pickup) per hop and vehicle type
+func GetLegTimes(c *gin.Context) {
+	db := db.GetDB()
+	district := getDistrictScope(c)
+
+	type Result struct {
+		FromName     string  `json:"from_name"`
+		ToName       string  `json:"to_name"`
+		VehicleType  string  `json:"vehicle_type"`
+		Legs         int     `json:"legs"`
+		TransitHours float64 `json:"transit_hours"`
+		DwellHours   float64 `json:"dwell_hours"` // 0 for first legs, which start at the donor
+	}
+	var results []Result
+
+	query := db.Table("transfer_legs l").
+		Select(`fa.name as from_name, fb.name as to_name, l.vehicle_type,
+			COUNT(*) as legs,
+			COALESCE(AVG(EXTRACT(EPOCH FROM (l.handed_over_at - l.picked_up_at))/3600), 0) as transit_hours,
+			COALESCE(AVG(EXTRACT(EPOCH FROM (l.picked_up_at - prev.handed_over_at))/3600), 0) as dwell_hours`).
+		Joins("JOIN facilities fa ON fa.id = l.from_facility_id").
+		Joins("JOIN facilities fb ON fb.id = l.to_facility_id").
+		Joins("LEFT JOIN transfer_legs prev ON prev.transfer_id = l.transfer_id AND prev.sequence = l.sequence - 1").
+		Where("l.picked_up_at IS NOT NULL AND l.handed_over_at IS NOT NULL").
+		Where("l.created_at > NOW() - INTERVAL '60 days'")
+
+	if district != "" {
+		query = query.Where("(fa.district = ? OR fb.district = ?)", district, district)
+	}
+
+	query.Group("fa.name, fb.name, l.vehicle_type").Order("legs DESC").Scan(&results)
+	c.JSON(http.StatusOK, results)
+}
+
 // 3. Consumption Trend
 func GetConsumptionTrend(c *gin.Context) {
 	db := db.GetDB()
@@ -208,12 +289,12 @@ func GetLogisticsPerformance(c *gin.Context) {
 	}
 	var results []Result
 
-	var count int64
+	var count int64 // Trips, not lines: costs accrue per vehicle run
 	query := db.Model(&models.Transfer{}).Joins("JOIN facilities f ON f.id = transfers.from_facility_id")
 	if district != "" {
 		query = query.Where("f.district = ?", district)
 	}
-	query.Count(&count)
+	query.Select("COUNT(DISTINCT " + tripKey + ")").Scan(&count)
 
 	results = append(results, Result{Name: "Fuel", Value: int(count * 200)})
 	results = append(results, Result{Name: "Driver Wages", Value: 150000}) 
@@ -223,22 +304,48 @@ func GetLogisticsPerformance(c *gin.Context) {
 	c.JSON(http.StatusOK, results)
 }
 
-// 7. AI Adoption Rate
+// 7. AI Adoption Rate (+ partial approvals and counter-proposal overrides)
 func GetAIAdoptionRate(c *gin.Context) {
 	db := db.GetDB()
 	district := getDistrictScope(c)
 
-	var rate float64
+	var stats struct {
+		Total      int64
+		Approved   int64
+		Partial    int64
+		Overridden int64
+	}
 	query := db.Table("solution_cards").
-		Select("COALESCE(ROUND((COUNT(CASE WHEN status = 'approved' THEN 1 END)::numeric / NULLIF(COUNT(*),0)) * 100, 1), 0)").
-		Joins("JOIN facilities f ON f.id = solution_cards.from_facilityid")
+		Select(`COUNT(*) as total,
+			COUNT(CASE WHEN status = 'approved' THEN 1 END) as approved,
+			COUNT(CASE WHEN status = 'approved' AND approved_quantity < proposed_quantity THEN 1 END) as partial,
+			COUNT(CASE WHEN EXISTS (SELECT 1 FROM solution_cards s WHERE s.parent_card_id = solution_cards.id) THEN 1 END) as overridden`).
+		Joins("JOIN facilities f ON f.id = solution_cards.from_facilityid").
+		Where("solution_cards.parent_card_id IS NULL"). // Only judge original recommendations
+		Where("solution_cards.status <> ?", models.CardExpired) // Expired cards were never decided by a human
 
 	if district != "" {
 		query = query.Where("f.district = ?", district)
 	}
 
-	query.Scan(&rate)
-	c.JSON(http.StatusOK, gin.H{"adoption_rate": rate})
+	query.Scan(&stats)
+
+	rate := func(n int64) float64 {
+		if stats.Total == 0 {
+			return 0
+		}
+		return math.Round(float64(n)/float64(stats.Total)*1000) / 10
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"adoption_rate":    rate(stats.Approved),
+		"override_rate":    rate(stats.Overridden),
+		"total_cards":      stats.Total,
+		"approved_cards":   stats.Approved,
+		"approved_full":    stats.Approved - stats.Partial,
+		"approved_partial": stats.Partial,
+		"overridden":       stats.Overridden,
+	})
 }
 
 // 8. SOP Violations
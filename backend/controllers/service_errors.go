@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"backend/services"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// respondServiceError maps the domain service sentinels onto HTTP codes.
+// Anything else is a database or internal failure: it is logged here and
+// the client only gets a generic message.
+func respondServiceError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, services.ErrInvalidTransition), errors.Is(err, services.ErrAlreadyProcessed),
+		errors.Is(err, services.ErrInsufficientStock), errors.Is(err, services.ErrJobRunning):
+		status = http.StatusConflict
+	case errors.Is(err, services.ErrInvalidInput):
+		status = http.StatusBadRequest
+	case errors.Is(err, services.ErrForbidden):
+		status = http.StatusForbidden
+	default:
+		log.Printf("Service Error on %s %s: %v", c.Request.Method, c.FullPath(), err)
+		c.JSON(status, gin.H{"error": "Db Error"})
+		return
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
@@ -1,13 +1,14 @@
 package controllers
 import (
 	"backend/db"
+	"backend/events"
 	"backend/models"
+	"backend/services"
 	"net/http"
+	"strconv"
+	"strings"
 	"github.com/gin-gonic/gin"
-	"fmt"
-	"time"
-	"math/rand"
-	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 func GetPendingSolutions(c *gin.Context) {
 	db := db.GetDB()
@@ -17,7 +18,7 @@ func GetPendingSolutions(c *gin.Context) {
 	medType := c.Query("type")      // 'Antibiotic', 'Analgesic'
 	search := c.Query("search")     // Search facility name
 
-	query := db.Model(&models.SolutionCard{}).Where("status = ?", "pending")
+	query := db.Model(&models.SolutionCard{}).Where("status = ?", models.CardPending)
 
 	// 1. Filter by Priority
 	if priority != "" {
@@ -36,7 +37,8 @@ func GetPendingSolutions(c *gin.Context) {
 		// We assume payload has "item_name". To filter by *type*, we'd ideally join with items table.
 		// For hackathon speed, let's do a JOIN-like subquery or assume payload has category.
 		// Robust way: Join with Items table using payload->>'item_id'
-		query = query.Joins("JOIN items ON items.id = (solution_cards.payload->>'item_id')::text").Where("items.therapeutic_class = ?", medType)
+		// Older ML cards only name the item, so match on the name as well
+		query = query.Joins("JOIN items ON items.id = (solution_cards.payload->>'item_id')::text OR items.name ILIKE solution_cards.payload->>'item_name'").Where("items.therapeutic_class = ?", medType)
 		// Simpler way: Just ignore this filter if it's too complex for now, OR rely on frontend filtering.
 		// Let's try the text search on payload for now if you seeded type there, otherwise skip backend filtering for type.
 	}
@@ -55,8 +57,10 @@ func GetPendingSolutions(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Db Error"})
 		return
 	}
+	// Cards still waiting for donor consent are not the DHO's to decide yet
+	cards = services.FilterByStep(db, cards, services.StepDHO)
 
-	c.JSON(http.StatusOK, cards)
+	c.JSON(http.StatusOK, services.CanonicalPayloads(db, cards))
 }
 
 // RejectTransfer marks a card as rejected
@@ -64,138 +68,138 @@ func RejectTransfer(c *gin.Context) {
 	id := c.Param("id")
 	db := db.GetDB()
 
-	// Update status
-	if err := db.Model(&models.SolutionCard{}).Where("id = ?", id).Update("status", "rejected").Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject"})
+	var result *services.ApprovalResult
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		result, err = services.RejectCard(tx, id, services.RejectionInput{
+			ActorID:  getContextString(c, "user_id", ""),
+			Role:     getContextString(c, "role", ""),
+			District: getDistrictScope(c),
+		})
+		return err
+	})
+	if err != nil {
+		respondServiceError(c, err)
 		return
 	}
+	events.PublishCard(db, events.CardRejected, id)
 
-	c.JSON(http.StatusOK, gin.H{"message": "Transfer Rejected"})
+	c.JSON(http.StatusOK, result)
 }
 // ApproveTransfer executes the stock movement transaction
 func ApproveTransfer(c *gin.Context) {
 	id := c.Param("id")
 	db := db.GetDB()
 
-	// 1. Start Transaction (ACID compliance is critical here)
-	tx := db.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-		}
-	}()
-
-	// 2. Find the Solution Card
-	var card models.SolutionCard
-	// We lock the row to prevent double-clicking issues
-	if err := tx.Set("gorm:query_option", "FOR UPDATE").First(&card, "id = ?", id).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusNotFound, gin.H{"error": "Card not found"})
+	// Optional body: {"quantity": n} approves less than the card proposed
+	var input struct {
+		Quantity interface{} `json:"quantity"`
+	}
+	_ = c.ShouldBindJSON(&input)
+	qty, ok := parseQuantity(input.Quantity)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "quantity must be a whole number"})
 		return
 	}
 
-	if card.Status != "pending" {
-		tx.Rollback()
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Request already processed"})
+	// ACID: stock movement, transfer and card status commit together
+	var result *services.ApprovalResult
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		result, err = services.ApproveCard(tx, id, services.ApprovalInput{
+			ActorID:  getContextString(c, "user_id", ""),
+			Role:     getContextString(c, "role", ""),
+			District: getDistrictScope(c),
+			Quantity: qty,
+		})
+		return err
+	})
+	if err != nil {
+		respondServiceError(c, err)
 		return
 	}
+	events.PublishCard(db, events.CardApproved, id)
+	events.PublishTransferByID(db, result.TransferID)
 
-	// 3. Parse Payload safely
-	payload := card.Payload
-	srcID, _ := payload["source_facility_id"].(string)
-	destID, _ := payload["destination_facility_id"].(string)
-	itemID, _ := payload["item_id"].(string)
-	// Handle JSON number parsing (float64 -> int)
-	qtyFloat, _ := payload["quantity"].(float64)
-	qty := int(qtyFloat)
-	
-	// Default vehicle if missing
-	vehicleType := "BIKE"
-	if val, ok := payload["transport_mode"].(string); ok {
-		vehicleType = val
-	}
+	c.JSON(http.StatusOK, result)
+}
 
-	// 4. Validate Source Stock
-	var srcInv models.Inventory
-	if err := tx.Where("facility_id = ? AND item_id = ?", srcID, itemID).First(&srcInv).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Source facility does not have this item"})
-		return
-	}
+// RejectAndModifyTransfer rejects a card and proposes an alternate donor/quantity
+func RejectAndModifyTransfer(c *gin.Context) {
+	db := db.GetDB()
 
-	if srcInv.Quantity < qty {
-		tx.Rollback()
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock at donor facility"})
+	// The web CardAction posts the card ID as transferId and the quantity as a string
+	var input struct {
+		CardID         string      `json:"transferId" binding:"required"`
+		AlternateDonor string      `json:"alternateDonor" binding:"required"`
+		AlternateQty   interface{} `json:"alternateQty"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "transferId and alternateDonor are required"})
 		return
 	}
 
-	// 5. Execute Stock Movement
-	// Deduct from Source
-	if err := tx.Model(&srcInv).Update("quantity", srcInv.Quantity - qty).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deduct stock"})
+	qty, ok := parseQuantity(input.AlternateQty)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "alternateQty must be a whole number"})
 		return
 	}
 
-	// Add to Destination (Upsert)
-	// We use raw SQL for Upsert to handle the "Create if not exists" logic cleanly
-	if err := tx.Exec(`
-		INSERT INTO inventories (id, facility_id, item_id, quantity, updated_at, status) 
-		VALUES (uuid_generate_v4(), ?, ?, ?, NOW(), 'Healthy') 
-		ON CONFLICT (facility_id, item_id) 
-		DO UPDATE SET quantity = inventories.quantity + ?, updated_at = NOW()
-	`, destID, itemID, qty, qty).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add stock"})
+	var result *services.CounterproposalResult
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		result, err = services.RejectWithCounterproposal(tx, input.CardID, services.CounterproposalInput{
+			ActorID:  getContextString(c, "user_id", ""),
+			Role:     getContextString(c, "role", ""),
+			District: getDistrictScope(c),
+			Donor:    strings.TrimSpace(input.AlternateDonor),
+			Quantity: qty,
+		})
+		return err
+	})
+	if err != nil {
+		respondServiceError(c, err)
 		return
 	}
+	// The successor card is announced by the card watcher
+	events.PublishCard(db, events.CardRejected, result.RejectedCardID)
 
-	// 6. Auto-Assign Driver (Hackathon Logic)
-	// Find a random driver in the system
-	var driver models.User
-	if err := tx.Where("role = ?", "DRIVER").Order("RANDOM()").First(&driver).Error; err != nil {
-		// If no drivers, create the transfer without one (Pending Assignment)
-		// For demo, we'll proceed with nil driver if none found
-	}
+	c.JSON(http.StatusOK, result)
+}
 
-	// 7. Create Transfer Record
-	transfer := models.Transfer{
-		ID:             uuid.New().String(),
-		// SolutionCardID: card.ID,
-		FromFacilityID: srcID,
-		ToFacilityID:   destID,
-		ItemID:         itemID,
-		Quantity:       qty,
-		Status:         "PENDING", // Means "Approved, waiting for pickup"
-		VehicleType:    vehicleType,
-		VehicleNumber:  "MH-02-BZ-" + fmt.Sprintf("%d", rand.Intn(9999)),
-		CreatedAt:      time.Now(),
-		UpdatedAt:      time.Now(),
-	}
-	
-	if driver.ID != "" {
-		transfer.DriverID = &driver.ID
+// parseQuantity accepts a JSON number or numeric string; missing means 0.
+func parseQuantity(v interface{}) (int, bool) {
+	switch q := v.(type) {
+	case nil:
+		return 0, true
+	case float64:
+		return int(q), q == float64(int(q))
+	case string:
+		if strings.TrimSpace(q) == "" {
+			return 0, true
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(q))
+		return n, err == nil
 	}
+	return 0, false
+}
 
-	if err := tx.Create(&transfer).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create transfer log"})
-		return
-	}
+// GetSolutionHistory returns a card's history, oldest first
+func GetSolutionHistory(c *gin.Context) {
+	db := db.GetDB()
+	id := c.Param("id")
 
-	// 8. Update Card Status
-	if err := tx.Model(&card).Update("status", "approved").Error; err != nil {
-		tx.Rollback()
+	var card models.SolutionCard
+	if err := db.First(&card, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Card not found"})
 		return
 	}
 
-	// 9. Commit Transaction
-	tx.Commit()
+	var history []models.SolutionCardEvent
+	db.Where("card_id = ?", id).Order("created_at ASC").Find(&history)
 
 	c.JSON(http.StatusOK, gin.H{
-		"status": "success",
-		"message": "Transfer approved",
-		"transfer_id": transfer.ID,
-		"driver_assigned": driver.Email,
+		"card":    card,
+		"history": history,
 	})
-}
\ No newline at end of file
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"backend/events"
+	"backend/services"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// StreamEvents pushes district-scoped live updates (Server-Sent Events).
+// Reconnecting clients send Last-Event-ID (EventSource does this itself,
+// or ?last_event_id=) and receive what they missed; if that is no longer
+// buffered they get a "reset" event and should refetch their data.
+func StreamEvents(c *gin.Context) {
+	role := getContextString(c, "role", "")
+	scope := events.Scope{
+		District:     getContextString(c, "district", ""),
+		FacilityID:   getContextString(c, "facility_id", ""),
+		DistrictWide: role == services.RoleDHO,
+	}
+	if role == services.RoleDriver {
+		scope.DriverID = getContextString(c, "user_id", "")
+	}
+
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("last_event_id")
+	}
+	since, _ := strconv.ParseUint(lastID, 10, 64)
+
+	sub, backlog, complete := events.Default.Subscribe(scope, since)
+	defer sub.Close()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Disable proxy buffering
+
+	if !complete {
+		c.Render(-1, sse.Event{Event: "reset", Data: gin.H{"reason": "missed events are no longer buffered"}})
+	}
+	for _, e := range backlog {
+		c.Render(-1, toSSE(e))
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(25 * time.Second)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case e, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind; the client resumes from its last ID
+				return false
+			}
+			c.Render(-1, toSSE(e))
+			return true
+		case <-heartbeat.C:
+			c.Render(-1, sse.Event{Event: "heartbeat", Data: time.Now().Unix()})
+			return true
+		}
+	})
+}
+
+func toSSE(e events.Event) sse.Event {
+	return sse.Event{
+		Id:    strconv.FormatUint(e.ID, 10),
+		Event: e.Type,
+		Data:  e,
+	}
+}
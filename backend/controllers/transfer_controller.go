@@ -0,0 +1,442 @@
+package controllers
+
+import (
+	"backend/db"
+	"backend/events"
+	"backend/models"
+	"backend/services"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetTransfer returns a transfer together with its status history (and legs, if routed via hubs)
+func GetTransfer(c *gin.Context) {
+	db := db.GetDB()
+	id := c.Param("id")
+
+	if !canSeeTransfer(c, db, id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+		return
+	}
+
+	var transfer models.Transfer
+	if err := db.Preload("FromFacility").Preload("ToFacility").Preload("Item").Preload("Vehicle").
+		Preload("Legs", func(db *gorm.DB) *gorm.DB { return db.Order("sequence") }).
+		Preload("Legs.FromFacility").Preload("Legs.ToFacility").
+		First(&transfer, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+		return
+	}
+
+	var events []models.TransferEvent
+	db.Where("transfer_id = ?", id).Order("created_at ASC").Find(&events)
+
+	lateBy := transfer.LateBy(time.Now())
+	c.JSON(http.StatusOK, gin.H{
+		"transfer":     transfer,
+		"events":       events,
+		"late":         lateBy > 0,
+		"late_minutes": int(lateBy.Minutes()),
+	})
+}
+
+// PickUpTransfer: driver has collected the stock at the donor
+func PickUpTransfer(c *gin.Context) {
+	runTransition(c, models.TransferPickedUp)
+}
+
+// DispatchTransfer: vehicle has left the donor
+func DispatchTransfer(c *gin.Context) {
+	runTransition(c, models.TransferInTransit)
+}
+
+// CancelTransfer: called off before delivery; stock goes back to the donor
+func CancelTransfer(c *gin.Context) {
+	db := db.GetDB()
+
+	var input struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cancellation reason required"})
+		return
+	}
+	if getContextString(c, "role", "") == services.RoleDHO && !transferInDistrict(db, c.Param("id"), getDistrictScope(c)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Transfer is outside your district"})
+		return
+	}
+
+	var transfer *models.Transfer
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		transfer, err = services.CancelTransfer(tx, c.Param("id"), services.CancelInput{
+			ActorID:    getContextString(c, "user_id", ""),
+			Role:       getContextString(c, "role", ""),
+			FacilityID: getContextString(c, "facility_id", ""),
+			Reason:     input.Reason,
+		})
+		return err
+	})
+	if err != nil {
+		respondServiceError(c, err)
+		return
+	}
+	events.PublishTransfer(db, transfer)
+	if transfer.SolutionCardID != nil {
+		events.PublishCard(db, events.CardCancelled, *transfer.SolutionCardID)
+	}
+
+	resp := transitionResponse(transfer)
+	resp["cancel_reason"] = transfer.CancelReason
+	resp["cancelled_by"] = transfer.CancelledBy
+	c.JSON(http.StatusOK, resp)
+}
+
+// FailTransfer: lost, damaged or otherwise never arrived
+func FailTransfer(c *gin.Context) {
+	runTransition(c, models.TransferFailed)
+}
+
+func runTransition(c *gin.Context, to string) {
+	db := db.GetDB()
+	id := c.Param("id")
+
+	// Body is optional; only a free-text note is accepted
+	var input struct {
+		Note string `json:"note"`
+	}
+	_ = c.ShouldBindJSON(&input)
+
+	if getContextString(c, "role", "") == services.RoleDHO && !transferInDistrict(db, id, getDistrictScope(c)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Transfer is outside your district"})
+		return
+	}
+
+	var transfer *models.Transfer
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		transfer, err = services.StepTransfer(tx, id, to, services.StepInput{
+			ActorID: getContextString(c, "user_id", ""),
+			Role:    getContextString(c, "role", ""),
+			Note:    input.Note,
+		})
+		return err
+	})
+	if err != nil {
+		respondServiceError(c, err)
+		return
+	}
+	events.PublishTransfer(db, transfer)
+
+	c.JSON(http.StatusOK, transitionResponse(transfer))
+}
+
+// GetTransferQR issues the signed QR manifest for a custody step (?step=pickup|delivery)
+func GetTransferQR(c *gin.Context) {
+	db := db.GetDB()
+	step := strings.ToUpper(c.DefaultQuery("step", services.ScanStepPickup))
+
+	code, payload, err := services.IssueManifest(db, c.Param("id"), step, services.ScanInput{
+		UserID:     getContextString(c, "user_id", ""),
+		FacilityID: getContextString(c, "facility_id", ""),
+	})
+	if err != nil {
+		respondServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":     code,
+		"manifest": payload,
+	})
+}
+
+// ScanQR verifies a scanned manifest and advances the transfer. The scan
+// that hands over to the recipient carries the counted lines, as
+// /confirm-delivery does.
+func ScanQR(c *gin.Context) {
+	db := db.GetDB()
+
+	var input struct {
+		Code      string                  `json:"code" binding:"required"`
+		Lines     []services.ReceivedLine `json:"lines"`
+		Condition string                  `json:"condition"`
+		Note      string                  `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "QR code required"})
+		return
+	}
+
+	var transfer *models.Transfer
+	var report *services.DeliveryReport
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		transfer, report, err = services.ScanManifest(tx, input.Code, services.ScanInput{
+			UserID:     getContextString(c, "user_id", ""),
+			FacilityID: getContextString(c, "facility_id", ""),
+			Received:   input.Lines,
+			Condition:  input.Condition,
+			Note:       input.Note,
+		})
+		return err
+	})
+	if err != nil {
+		respondServiceError(c, err)
+		return
+	}
+	events.PublishTransfer(db, transfer)
+
+	resp := transitionResponse(transfer)
+	if report != nil {
+		resp["report"] = report
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func transitionResponse(t *models.Transfer) gin.H {
+	return gin.H{
+		"status":                 "success",
+		"transfer_id":            t.ID,
+		"state":                  t.Status,
+		"updated_at":             t.UpdatedAt,
+		"estimated_arrival_time": t.EstimatedArrivalTime,
+	}
+}
+
+// ConfirmDelivery: receiving PHC records what actually arrived
+func ConfirmDelivery(c *gin.Context) {
+	db := db.GetDB()
+
+	var input struct {
+		Lines     []services.ReceivedLine `json:"lines" binding:"required"`
+		Condition string                  `json:"condition"`
+		Note      string                  `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Received quantities required"})
+		return
+	}
+
+	var report *services.DeliveryReport
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		report, err = services.ConfirmDelivery(tx, c.Param("id"), services.DeliveryConfirmation{
+			ActorID:    getContextString(c, "user_id", ""),
+			FacilityID: getContextString(c, "facility_id", ""),
+			Lines:      input.Lines,
+			Condition:  input.Condition,
+			Note:       input.Note,
+		})
+		return err
+	})
+	if err != nil {
+		respondServiceError(c, err)
+		return
+	}
+	events.PublishTransferByID(db, report.TransferID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"report": report,
+	})
+}
+
+// GetDriverCandidates ranks drivers who could take a transfer (for manual reassignment)
+func GetDriverCandidates(c *gin.Context) {
+	db := db.GetDB()
+
+	if !requireTransferDHO(c, db, "Only the DHO can reassign drivers") {
+		return
+	}
+
+	var transfer models.Transfer
+	if err := db.Select("id, from_facility_id, quantity, cold_chain").First(&transfer, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+		return
+	}
+
+	candidates, err := services.RankDrivers(db, services.PickupFacilityID(db, &transfer), transfer.Quantity, transfer.ColdChain)
+	if err != nil {
+		respondServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, candidates)
+}
+
+// AssignTransferDriver lets the DHO reassign a driver (empty driver_id = auto-dispatch)
+func AssignTransferDriver(c *gin.Context) {
+	db := db.GetDB()
+
+	if !requireTransferDHO(c, db, "Only the DHO can reassign drivers") {
+		return
+	}
+
+	var input struct {
+		DriverID string `json:"driver_id"`
+	}
+	_ = c.ShouldBindJSON(&input)
+
+	var transfer *models.Transfer
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		transfer, err = services.AssignDriver(tx, c.Param("id"), input.DriverID, getContextString(c, "user_id", ""))
+		return err
+	})
+	if err != nil {
+		respondServiceError(c, err)
+		return
+	}
+	events.PublishTransfer(db, transfer)
+
+	resp := transitionResponse(transfer)
+	resp["driver_id"] = transfer.DriverID
+	c.JSON(http.StatusOK, resp)
+}
+
+// RouteTransfer lets the DHO send a transfer through hubs before pickup (empty via = direct)
+func RouteTransfer(c *gin.Context) {
+	db := db.GetDB()
+
+	if !requireTransferDHO(c, db, "Only the DHO can route transfers") {
+		return
+	}
+
+	var input struct {
+		Via []string `json:"via"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid route"})
+		return
+	}
+
+	var transfer *models.Transfer
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		transfer, err = services.RouteTransfer(tx, c.Param("id"), input.Via, getContextString(c, "user_id", ""))
+		return err
+	})
+	if err != nil {
+		respondServiceError(c, err)
+		return
+	}
+	events.PublishTransfer(db, transfer)
+
+	resp := transitionResponse(transfer)
+	resp["legs"] = transfer.Legs
+	c.JSON(http.StatusOK, resp)
+}
+
+// PostTransferLocation: driver app reports a GPS fix for the transfer it is carrying
+func PostTransferLocation(c *gin.Context) {
+	db := db.GetDB()
+
+	var input struct {
+		Lat        *float64   `json:"lat" binding:"required"`
+		Lng        *float64   `json:"lng" binding:"required"`
+		AccuracyM  *float64   `json:"accuracy_m"`
+		SpeedKmh   *float64   `json:"speed_kmh"`
+		RecordedAt *time.Time `json:"recorded_at"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "lat and lng are required"})
+		return
+	}
+
+	ping := services.PingInput{
+		DriverID:  getContextString(c, "user_id", ""),
+		Lat:       *input.Lat,
+		Lng:       *input.Lng,
+		AccuracyM: input.AccuracyM,
+		SpeedKmh:  input.SpeedKmh,
+	}
+	if input.RecordedAt != nil {
+		ping.RecordedAt = *input.RecordedAt
+	}
+
+	var saved *models.LocationPing
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		saved, err = services.RecordPing(tx, c.Param("id"), ping)
+		return err
+	})
+	if err != nil {
+		respondServiceError(c, err)
+		return
+	}
+	events.PublishPosition(db, saved)
+
+	c.JSON(http.StatusCreated, saved)
+}
+
+// GetTransferTracking returns latest position, trail and progress (?limit= trail points)
+func GetTransferTracking(c *gin.Context) {
+	db := db.GetDB()
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "200"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 200
+	}
+	if !canSeeTransfer(c, db, c.Param("id")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+		return
+	}
+
+	tracking, err := services.TrackTransfer(db, c.Param("id"), limit)
+	if err != nil {
+		respondServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tracking)
+}
+
+// requireTransferDHO answers 403 unless the caller is the DHO of a district
+// the transfer (c.Param("id")) starts or ends in.
+func requireTransferDHO(c *gin.Context, db *gorm.DB, denied string) bool {
+	if getContextString(c, "role", "") != services.RoleDHO {
+		c.JSON(http.StatusForbidden, gin.H{"error": denied})
+		return false
+	}
+	if !transferInDistrict(db, c.Param("id"), getDistrictScope(c)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Transfer is outside your district"})
+		return false
+	}
+	return true
+}
+
+// transferInDistrict reports whether either end of a transfer lies in the district.
+func transferInDistrict(db *gorm.DB, transferID, district string) bool {
+	if district == "" {
+		return false
+	}
+	var n int64
+	db.Table("transfers").
+		Joins("JOIN facilities f ON f.id IN (transfers.from_facility_id, transfers.to_facility_id)").
+		Where("transfers.id = ? AND f.district = ?", transferID, district).
+		Count(&n)
+	return n > 0
+}
+
+// canSeeTransfer: the DHO sees transfers touching their district; anyone
+// else only those they drive, or that start, end or stop over at their
+// facility.
+func canSeeTransfer(c *gin.Context, db *gorm.DB, transferID string) bool {
+	if getContextString(c, "role", "") == services.RoleDHO {
+		return transferInDistrict(db, transferID, getDistrictScope(c))
+	}
+	user := getContextString(c, "user_id", "")
+	facility := getContextString(c, "facility_id", "")
+	var n int64
+	db.Table("transfers").
+		Joins("LEFT JOIN transfer_legs l ON l.transfer_id = transfers.id").
+		Where("transfers.id = ?", transferID).
+		Where("transfers.driver_id = ? OR l.driver_id = ? OR ? IN (transfers.from_facility_id, transfers.to_facility_id, l.from_facility_id, l.to_facility_id)",
+			user, user, facility).
+		Count(&n)
+	return n > 0
+}
@@ -0,0 +1,306 @@
+package controllers
+
+import (
+	"backend/db"
+	"backend/models"
+	"backend/services"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type vehicleInput struct {
+	Registration    string  `json:"registration" binding:"required"`
+	Type            string  `json:"type" binding:"required"`
+	ColdChain       bool    `json:"cold_chain"`
+	PayloadCapacity int     `json:"payload_capacity"`
+	HomeFacilityID  *string `json:"home_facility_id"`
+	Active          *bool   `json:"active"`
+}
+
+func (in *vehicleInput) validate() string {
+	in.Registration = strings.ToUpper(strings.TrimSpace(in.Registration))
+	in.Type = strings.ToUpper(in.Type)
+	switch in.Type {
+	case models.VehicleBike, models.VehicleVan, models.VehicleTruck:
+	default:
+		return "type must be BIKE, VAN or TRUCK"
+	}
+	if in.PayloadCapacity < 0 {
+		return "payload_capacity cannot be negative"
+	}
+	return ""
+}
+
+// fleetScope is whose fleet the caller manages: the DHO their district's
+// vehicles, an administrator every vehicle including the shared pool.
+type fleetScope struct {
+	District string
+	Admin    bool
+}
+
+// requireFleetManager stops the request unless the caller is an
+// administrator or a DHO with a district.
+func requireFleetManager(c *gin.Context) (fleetScope, bool) {
+	district := getDistrictScope(c)
+	switch getContextString(c, "role", "") {
+	case services.RoleAdmin:
+		return fleetScope{District: district, Admin: true}, true
+	case services.RoleDHO:
+		if district != "" {
+			return fleetScope{District: district}, true
+		}
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "Only the DHO can manage the fleet"})
+	return fleetScope{}, false
+}
+
+// manages reports whether the scope may place a vehicle at this home
+// facility. Vehicles without one are a shared pool every district draws
+// on, so only an administrator changes them.
+func (s fleetScope) manages(tx *gorm.DB, home *string) bool {
+	if home == nil || *home == "" {
+		return s.Admin
+	}
+	query := tx.Model(&models.Facility{}).Where("id = ?", *home)
+	if !s.Admin {
+		query = query.Where("district = ?", s.District)
+	}
+	var n int64
+	query.Count(&n)
+	return n > 0
+}
+
+// findManagedVehicle loads a vehicle the scope may change.
+func findManagedVehicle(tx *gorm.DB, id string, scope fleetScope) (*models.Vehicle, error) {
+	var vehicle models.Vehicle
+	if err := tx.First(&vehicle, "id = ?", id).Error; err != nil {
+		return nil, fmt.Errorf("%w: vehicle %s", services.ErrNotFound, id)
+	}
+	if !scope.manages(tx, vehicle.HomeFacilityID) {
+		if vehicle.HomeFacilityID == nil || *vehicle.HomeFacilityID == "" {
+			return nil, fmt.Errorf("%w: only an administrator can change shared-pool vehicles", services.ErrForbidden)
+		}
+		return nil, fmt.Errorf("%w: vehicle %s", services.ErrNotFound, id)
+	}
+	return &vehicle, nil
+}
+
+// fleetQuery is the vehicles a caller sees: their district's plus the
+// shared pool, or all of them for an administrator.
+func fleetQuery(c *gin.Context, db *gorm.DB) *gorm.DB {
+	query := db.Model(&models.Vehicle{})
+	if getContextString(c, "role", "") == services.RoleAdmin {
+		return query
+	}
+	district := getContextString(c, "district", "Mumbai_City")
+	return query.Joins("LEFT JOIN facilities f ON f.id = vehicles.home_facility_id").
+		Where("f.district = ? OR vehicles.home_facility_id IS NULL", district)
+}
+
+// GetVehicles lists the fleet (optional ?facility_id= home facility filter)
+func GetVehicles(c *gin.Context) {
+	db := db.GetDB()
+
+	query := fleetQuery(c, db)
+	if facilityID := c.Query("facility_id"); facilityID != "" {
+		query = query.Where("vehicles.home_facility_id = ?", facilityID)
+	}
+
+	var vehicles []models.Vehicle
+	if err := query.Order("vehicles.registration ASC").Find(&vehicles).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch vehicles"})
+		return
+	}
+	c.JSON(http.StatusOK, vehicles)
+}
+
+// GetVehicle returns one vehicle
+func GetVehicle(c *gin.Context) {
+	db := db.GetDB()
+	var vehicle models.Vehicle
+	if err := fleetQuery(c, db).First(&vehicle, "vehicles.id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Vehicle not found"})
+		return
+	}
+	c.JSON(http.StatusOK, vehicle)
+}
+
+// CreateVehicle registers a vehicle
+func CreateVehicle(c *gin.Context) {
+	db := db.GetDB()
+	scope, ok := requireFleetManager(c)
+	if !ok {
+		return
+	}
+
+	var input vehicleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "registration and type are required"})
+		return
+	}
+	if msg := input.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if !scope.manages(db, input.HomeFacilityID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "home_facility_id must be a facility in your district; shared-pool vehicles are the administrator's"})
+		return
+	}
+
+	vehicle := models.Vehicle{
+		ID:              uuid.New().String(),
+		Registration:    input.Registration,
+		Type:            input.Type,
+		ColdChain:       input.ColdChain,
+		PayloadCapacity: input.PayloadCapacity,
+		HomeFacilityID:  input.HomeFacilityID,
+		Active:          true,
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+	}
+	if err := db.Create(&vehicle).Error; err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Vehicle could not be registered (duplicate registration?)"})
+		return
+	}
+	c.JSON(http.StatusCreated, vehicle)
+}
+
+// UpdateVehicle edits a vehicle's details
+func UpdateVehicle(c *gin.Context) {
+	db := db.GetDB()
+	scope, ok := requireFleetManager(c)
+	if !ok {
+		return
+	}
+
+	vehicle, err := findManagedVehicle(db, c.Param("id"), scope)
+	if err != nil {
+		respondServiceError(c, err)
+		return
+	}
+
+	var input vehicleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "registration and type are required"})
+		return
+	}
+	if msg := input.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if !scope.manages(db, input.HomeFacilityID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "home_facility_id must be a facility in your district; shared-pool vehicles are the administrator's"})
+		return
+	}
+
+	updates := map[string]interface{}{
+		"registration":     input.Registration,
+		"type":             input.Type,
+		"cold_chain":       input.ColdChain,
+		"payload_capacity": input.PayloadCapacity,
+		"home_facility_id": input.HomeFacilityID,
+		"updated_at":       time.Now(),
+	}
+	if input.Active != nil {
+		updates["active"] = *input.Active
+	}
+	if err := db.Model(vehicle).Updates(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update vehicle"})
+		return
+	}
+	db.First(vehicle, "id = ?", vehicle.ID)
+	c.JSON(http.StatusOK, vehicle)
+}
+
+// DeleteVehicle removes a vehicle; vehicles with trip history are retired instead
+func DeleteVehicle(c *gin.Context) {
+	db := db.GetDB()
+	scope, ok := requireFleetManager(c)
+	if !ok {
+		return
+	}
+	id := c.Param("id")
+	if _, err := findManagedVehicle(db, id, scope); err != nil {
+		respondServiceError(c, err)
+		return
+	}
+
+	var trips int64
+	db.Model(&models.Transfer{}).Where("vehicle_id = ?", id).Count(&trips)
+
+	var result *gorm.DB
+	if trips > 0 {
+		result = db.Model(&models.Vehicle{}).Where("id = ?", id).
+			Updates(map[string]interface{}{"active": false, "driver_id": nil, "updated_at": time.Now()})
+	} else {
+		result = db.Delete(&models.Vehicle{}, "id = ?", id)
+	}
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete vehicle"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Vehicle not found"})
+		return
+	}
+
+	if trips > 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "Vehicle has trip history and was retired"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Vehicle deleted"})
+}
+
+// AssignVehicleDriver pairs a driver with a vehicle (empty driver_id unassigns)
+func AssignVehicleDriver(c *gin.Context) {
+	db := db.GetDB()
+	scope, ok := requireFleetManager(c)
+	if !ok {
+		return
+	}
+	id := c.Param("id")
+
+	var input struct {
+		DriverID string `json:"driver_id"`
+	}
+	_ = c.ShouldBindJSON(&input)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		vehicle, err := findManagedVehicle(tx, id, scope)
+		if err != nil {
+			return err
+		}
+
+		var driverID interface{}
+		if input.DriverID != "" {
+			var driver models.User
+			query := tx.Where("id = ? AND role = ?", input.DriverID, services.RoleDriver)
+			if !scope.Admin {
+				query = query.Where("district = ?", scope.District)
+			}
+			if err := query.First(&driver).Error; err != nil {
+				return fmt.Errorf("%w: driver %s", services.ErrNotFound, input.DriverID)
+			}
+			// A driver drives one vehicle at a time
+			if err := tx.Model(&models.Vehicle{}).Where("driver_id = ? AND id <> ?", driver.ID, vehicle.ID).
+				Update("driver_id", nil).Error; err != nil {
+				return err
+			}
+			driverID = driver.ID
+		}
+
+		return tx.Model(vehicle).Updates(map[string]interface{}{"driver_id": driverID, "updated_at": time.Now()}).Error
+	})
+	if err != nil {
+		respondServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Driver assignment updated"})
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"backend/models"
+	"log"
+)
+
+// Migrate creates the tables and columns owned by the Go backend.
+// The core schema lives in Supabase, so existing tables only ever get
+// missing columns added here; nothing is altered or dropped.
+func Migrate() {
+	if err := DB.AutoMigrate(&models.TransferEvent{}, &models.Vehicle{}, &models.LocationPing{},
+		&models.IdempotencyRecord{}, &models.SolutionCardEvent{}, &models.JobRun{}, &models.StockRequest{},
+		&models.Consignment{}, &models.TransferLeg{}, &models.TemperatureReading{}); err != nil {
+		log.Fatal("❌ Migration failed:", err)
+	}
+
+	addMissingColumns(&models.Transfer{}, "PickedUpAt", "StockReserved", "ProposedQuantity", "Batches",
+		"ReceivedQuantity", "DeliveryCondition", "DeliveryNote", "VehicleID", "ConsignmentID",
+		"CancelledAt", "CancelledBy", "CancelReason", "ColdChain", "TempExcursion")
+	addMissingColumns(&models.Item{}, "StorageCondition", "MinTempC", "MaxTempC")
+	addMissingColumns(&models.Inventory{}, "ReservedQuantity")
+	addMissingColumns(&models.User{}, "OnDuty", "ShiftStart", "ShiftEnd")
+	addMissingColumns(&models.SolutionCard{}, "ParentCardID", "ProposedQuantity", "ApprovedQuantity", "IdempotencyToken",
+		"ApprovedBy", "DonorConsent", "DonorConsentBy", "DonorConsentAt", "DonorDeclineReason")
+	addMissingIndex(&models.SolutionCard{}, "idx_solution_cards_idempotency_token")
+
+	// Older rows were written before the lifecycle existed: "PENDING"
+	// meant approved-and-waiting, delivery had three spellings. Rows with
+	// lifecycle events are already canonical and are left alone.
+	DB.Exec(`UPDATE transfers SET status = ? WHERE status = ?
+		AND id::text NOT IN (SELECT transfer_id FROM transfer_events)`, models.TransferApproved, models.TransferPending)
+	DB.Exec("UPDATE transfers SET status = ? WHERE status IN ?", models.TransferDelivered, []string{"completed", "COMPLETED"})
+
+	log.Println("✅ Schema migrations applied")
+}
+
+func addMissingColumns(model interface{}, fields ...string) {
+	m := DB.Migrator()
+	for _, field := range fields {
+		if m.HasColumn(model, field) {
+			continue
+		}
+		if err := m.AddColumn(model, field); err != nil {
+			log.Fatalf("❌ Failed to add column %s: %v", field, err)
+		}
+	}
+}
+
+// addMissingIndex is best-effort: existing duplicate rows are reported
+// rather than stopping the server.
+func addMissingIndex(model interface{}, name string) {
+	m := DB.Migrator()
+	if m.HasIndex(model, name) {
+		return
+	}
+	if err := m.CreateIndex(model, name); err != nil {
+		log.Printf("⚠️ Could not create index %s: %v", name, err)
+	}
+}
@@ -0,0 +1,177 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types pushed to dashboard streams
+const (
+	CardCreated     = "card.created"
+	CardApproved    = "card.approved"
+	CardRejected    = "card.rejected"
+	CardExpired     = "card.expired"
+	CardEscalated   = "card.escalated"
+	CardConsented   = "card.consented"
+	CardCancelled   = "card.cancelled"
+	TransferStatus  = "transfer.status"
+	DriverPosition  = "driver.position"
+	InventoryStatus = "inventory.status"
+)
+
+// Event is one change notification. Districts, Facilities and Drivers
+// decide who may receive it; they are not sent to clients.
+type Event struct {
+	ID         uint64      `json:"id"`
+	Type       string      `json:"type"`
+	Data       interface{} `json:"data"`
+	At         time.Time   `json:"at"`
+	Districts  []string    `json:"-"`
+	Facilities []string    `json:"-"`
+	Drivers    []string    `json:"-"`
+}
+
+// Scope is what a subscriber's JWT allows it to see. District-wide
+// subscribers (DHOs) see every event in their district; facility staff
+// only see events that involve their facility; drivers only see the
+// transfers they drive.
+type Scope struct {
+	District     string
+	FacilityID   string
+	DriverID     string
+	DistrictWide bool
+}
+
+func (s Scope) allows(e Event) bool {
+	if s.DriverID != "" {
+		return contains(e.Drivers, s.DriverID)
+	}
+	if !contains(e.Districts, s.District) {
+		return false
+	}
+	if s.DistrictWide || len(e.Facilities) == 0 {
+		return true
+	}
+	return contains(e.Facilities, s.FacilityID)
+}
+
+// Subscription receives live events until it is closed. C is closed when
+// the subscriber falls too far behind; the client should reconnect and
+// resume from its last event ID.
+type Subscription struct {
+	C      chan Event
+	scope  Scope
+	broker *Broker
+}
+
+// Close detaches the subscription from the broker.
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	if _, ok := s.broker.subs[s]; ok {
+		delete(s.broker.subs, s)
+		close(s.C)
+	}
+}
+
+// Broker fans events out to subscribers and keeps the most recent ones in
+// a ring buffer so reconnecting clients can catch up.
+type Broker struct {
+	mu     sync.Mutex
+	nextID uint64
+	ring   []Event
+	head   int // Index of the oldest buffered event
+	count  int
+	subs   map[*Subscription]struct{}
+}
+
+// NewBroker keeps the last `size` events for resumption. IDs start at the
+// boot time in milliseconds so they keep increasing across restarts and a
+// stale Last-Event-ID from a previous process is detected as a gap.
+func NewBroker(size int) *Broker {
+	return &Broker{
+		nextID: uint64(time.Now().UnixMilli()),
+		ring:   make([]Event, size),
+		subs:   make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish stamps the event with an ID and delivers it to every subscriber
+// in scope. Slow subscribers are dropped rather than blocking publishers.
+func (b *Broker) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	e.ID = b.nextID
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+
+	if len(b.ring) > 0 {
+		if b.count < len(b.ring) {
+			b.ring[(b.head+b.count)%len(b.ring)] = e
+			b.count++
+		} else {
+			b.ring[b.head] = e
+			b.head = (b.head + 1) % len(b.ring)
+		}
+	}
+
+	for sub := range b.subs {
+		if !sub.scope.allows(e) {
+			continue
+		}
+		select {
+		case sub.C <- e:
+		default:
+			delete(b.subs, sub)
+			close(sub.C)
+		}
+	}
+}
+
+// Subscribe registers a live subscription and returns the buffered events
+// after lastID that the scope allows. complete is false when events after
+// lastID have already been evicted, so the client must refetch its state.
+// A lastID of 0 means a fresh connection with nothing to replay.
+func (b *Broker) Subscribe(scope Scope, lastID uint64) (sub *Subscription, backlog []Event, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	complete = true
+	if lastID > 0 && lastID < b.nextID {
+		oldest := b.nextID + 1
+		if b.count > 0 {
+			oldest = b.ring[b.head].ID
+		}
+		complete = lastID+1 >= oldest
+		for i := 0; i < b.count; i++ {
+			e := b.ring[(b.head+i)%len(b.ring)]
+			if e.ID > lastID && scope.allows(e) {
+				backlog = append(backlog, e)
+			}
+		}
+	}
+
+	sub = &Subscription{C: make(chan Event, 64), scope: scope, broker: b}
+	b.subs[sub] = struct{}{}
+	return sub, backlog, complete
+}
+
+// Default is the process-wide broker.
+var Default = NewBroker(1000)
+
+// Publish sends an event through the default broker.
+func Publish(e Event) {
+	Default.Publish(e)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
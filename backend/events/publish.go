@@ -0,0 +1,140 @@
+package events
+
+import (
+	"backend/models"
+
+	"gorm.io/gorm"
+)
+
+// Helpers used by controllers once their transaction has committed, so
+// subscribers never see a change that was rolled back.
+
+// PublishTransfer announces a transfer's current state. A consignment line
+// announces every line, since they move together.
+func PublishTransfer(tx *gorm.DB, t *models.Transfer) {
+	if t.ConsignmentID != nil {
+		PublishConsignment(tx, *t.ConsignmentID)
+		return
+	}
+	publishTransfer(tx, t)
+}
+
+// PublishConsignment announces every line of a consignment.
+func PublishConsignment(tx *gorm.DB, consignmentID string) {
+	var lines []models.Transfer
+	tx.Where("consignment_id = ?", consignmentID).Order("id").Find(&lines)
+	for i := range lines {
+		publishTransfer(tx, &lines[i])
+	}
+}
+
+func publishTransfer(tx *gorm.DB, t *models.Transfer) {
+	Publish(Event{
+		Type: TransferStatus,
+		Data: map[string]interface{}{
+			"transfer_id":            t.ID,
+			"consignment_id":         t.ConsignmentID,
+			"status":                 t.Status,
+			"from_facility_id":       t.FromFacilityID,
+			"to_facility_id":         t.ToFacilityID,
+			"item_id":                t.ItemID,
+			"quantity":               t.Quantity,
+			"driver_id":              t.DriverID,
+			"estimated_arrival_time": t.EstimatedArrivalTime,
+			"updated_at":             t.UpdatedAt,
+		},
+		Districts:  districtsOf(tx, t.FromFacilityID, t.ToFacilityID),
+		Facilities: []string{t.FromFacilityID, t.ToFacilityID},
+		Drivers:    driversOf(tx, t),
+	})
+}
+
+// PublishTransferByID loads a transfer and announces its state.
+func PublishTransferByID(tx *gorm.DB, transferID string) {
+	if transferID == "" {
+		return
+	}
+	var t models.Transfer
+	if err := tx.First(&t, "id = ?", transferID).Error; err == nil {
+		PublishTransfer(tx, &t)
+	}
+}
+
+// PublishCard announces a card event (created, approved or rejected).
+func PublishCard(tx *gorm.DB, eventType, cardID string) {
+	var card models.SolutionCard
+	if err := tx.First(&card, "id = ?", cardID).Error; err != nil {
+		return
+	}
+	facilities := cardFacilities(card)
+	Publish(Event{
+		Type: eventType,
+		Data: map[string]interface{}{
+			"card_id":           card.ID,
+			"status":            card.Status,
+			"source":            card.Source,
+			"priority_score":    card.PriorityScore,
+			"from_facility_id":  card.FromFacilityID,
+			"to_facility_id":    card.ToFacilityID,
+			"approved_quantity": card.ApprovedQuantity,
+			"created_at":        card.CreatedAt,
+		},
+		Districts:  districtsOf(tx, facilities...),
+		Facilities: facilities,
+	})
+}
+
+// PublishPosition announces a driver's latest fix for a transfer.
+func PublishPosition(tx *gorm.DB, ping *models.LocationPing) {
+	var t models.Transfer
+	if err := tx.First(&t, "id = ?", ping.TransferID).Error; err != nil {
+		return
+	}
+	Publish(Event{
+		Type: DriverPosition,
+		Data: map[string]interface{}{
+			"transfer_id":            t.ID,
+			"driver_id":              ping.DriverID,
+			"lat":                    ping.Lat,
+			"lng":                    ping.Lng,
+			"recorded_at":            ping.RecordedAt,
+			"estimated_arrival_time": t.EstimatedArrivalTime,
+		},
+		Districts:  districtsOf(tx, t.FromFacilityID, t.ToFacilityID),
+		Facilities: []string{t.FromFacilityID, t.ToFacilityID},
+		Drivers:    driversOf(tx, &t),
+	})
+}
+
+// driversOf lists the drivers of a transfer, including every leg's driver
+// when it is routed via hubs.
+func driversOf(tx *gorm.DB, t *models.Transfer) []string {
+	var drivers []string
+	tx.Model(&models.TransferLeg{}).Where("transfer_id = ? AND driver_id IS NOT NULL", t.ID).Distinct().Pluck("driver_id", &drivers)
+	if t.DriverID != nil && !contains(drivers, *t.DriverID) {
+		drivers = append(drivers, *t.DriverID)
+	}
+	return drivers
+}
+
+// cardFacilities reads donor/recipient from the card, whatever shape its
+// payload is in.
+func cardFacilities(card models.SolutionCard) []string {
+	var ids []string
+	p := models.ParseCardPayload(&card)
+	for _, id := range []string{p.SourceFacilityID, p.DestinationFacilityID} {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func districtsOf(tx *gorm.DB, facilityIDs ...string) []string {
+	if len(facilityIDs) == 0 {
+		return nil
+	}
+	var districts []string
+	tx.Model(&models.Facility{}).Where("id IN ?", facilityIDs).Distinct().Pluck("district", &districts)
+	return districts
+}
@@ -0,0 +1,95 @@
+package events
+
+import (
+	"backend/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Watch polls for changes written straight to Postgres by the ML agent —
+// new solution cards and inventory status flips — and publishes them.
+// Cards the Go backend creates are picked up the same way, so card.created
+// has a single source.
+func Watch(db *gorm.DB, every time.Duration) {
+	w := &watcher{db: db, since: time.Now(), seen: map[string]bool{}, statuses: map[string]string{}}
+	w.pollInventory(false)
+	go func() {
+		for range time.Tick(every) {
+			w.pollCards()
+			w.pollInventory(true)
+		}
+	}()
+}
+
+type watcher struct {
+	db       *gorm.DB
+	since    time.Time       // created_at of the newest card published
+	seen     map[string]bool // Cards published with created_at == since
+	statuses map[string]string
+	changed  time.Time // updated_at of the newest inventory row read
+}
+
+func (w *watcher) pollCards() {
+	var cards []models.SolutionCard
+	if err := w.db.Select("id, created_at").Where("created_at >= ?", w.since).
+		Order("created_at ASC").Find(&cards).Error; err != nil {
+		return
+	}
+	for _, card := range cards {
+		if w.seen[card.ID] {
+			continue
+		}
+		PublishCard(w.db, CardCreated, card.ID)
+		if card.CreatedAt.After(w.since) {
+			w.since = card.CreatedAt
+			w.seen = map[string]bool{}
+		}
+		w.seen[card.ID] = true
+	}
+}
+
+// pollInventory reads the rows updated since the last poll (all of them the
+// first time) and publishes status flips. Rows stamped exactly at the last
+// poll are read again; an unchanged status is not republished.
+func (w *watcher) pollInventory(publish bool) {
+	var rows []struct {
+		ID         string
+		FacilityID string
+		ItemID     string
+		Status     string
+		District   string
+		UpdatedAt  *time.Time
+	}
+	query := w.db.Table("inventories").
+		Select("inventories.id, inventories.facility_id, inventories.item_id, inventories.status, inventories.updated_at, f.district").
+		Joins("JOIN facilities f ON f.id = inventories.facility_id")
+	if !w.changed.IsZero() {
+		query = query.Where("inventories.updated_at >= ?", w.changed)
+	}
+	if err := query.Scan(&rows).Error; err != nil {
+		return
+	}
+	for _, r := range rows {
+		if r.UpdatedAt != nil && r.UpdatedAt.After(w.changed) {
+			w.changed = *r.UpdatedAt
+		}
+		prev, known := w.statuses[r.ID]
+		w.statuses[r.ID] = r.Status
+		if !publish || !known || prev == r.Status {
+			continue
+		}
+		Publish(Event{
+			Type: InventoryStatus,
+			Data: map[string]interface{}{
+				"inventory_id":    r.ID,
+				"facility_id":     r.FacilityID,
+				"item_id":         r.ItemID,
+				"status":          r.Status,
+				"previous_status": prev,
+			},
+			Districts:  []string{r.District},
+			Facilities: []string{r.FacilityID},
+		})
+	}
+}
@@ -3,9 +3,12 @@ package main
 import (
 	"backend/controllers"
 	"backend/db"
+	"backend/events"
 	"backend/middleware"
+	"backend/workers"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -20,8 +23,12 @@ func main() {
 
 	// 2. Database
 	db.ConnectDB()
-	// 3. Router
-	r := gin.Default()
+	db.Migrate()
+	events.Watch(db.GetDB(), 5*time.Second)
+	workers.StartScheduler(db.GetDB(), time.Minute)
+	// 3. Router (stream tokens come off the URL before it is logged)
+	r := gin.New()
+	r.Use(middleware.StripAccessToken(), gin.Logger(), gin.Recovery())
 
 	// 4. CORS
 	r.Use(middleware.CORSMiddleware())
@@ -36,8 +43,15 @@ func main() {
 		// Authentication
 		api.POST("/auth/login", controllers.LoginHandler)
 
+		// Live updates (SSE); EventSource cannot set headers, so the token may be a query param
+		stream := api.Group("/stream")
+		stream.Use(middleware.StreamAuthMiddleware())
+		{
+			stream.GET("/events", controllers.StreamEvents)
+		}
+
 		protected := api.Group("/")
-		protected.Use(middleware.AuthMiddleware())
+		protected.Use(middleware.AuthMiddleware(), middleware.IdempotencyMiddleware())
 		{
 			// Dashboard
 			protected.GET("/basic-info", controllers.GetBasicInfo)
@@ -58,6 +72,8 @@ protected.GET("/alerts/feed", controllers.GetAlertsFeed)			// protected.GET("/al
 			protected.GET("/solutions/pending", controllers.GetPendingSolutions)
 			protected.POST("/solutions/:id/approve", controllers.ApproveTransfer)
 			protected.POST("/solutions/:id/reject", controllers.RejectTransfer)
+			protected.GET("/solutions/:id/history", controllers.GetSolutionHistory)
+			protected.POST("/recommendations", controllers.CreateRecommendation)
 			protected.GET("/facilities", controllers.GetFacilities) // <--- NEW
 
 
@@ -80,16 +96,59 @@ protected.GET("/alerts/feed", controllers.GetAlertsFeed)			// protected.GET("/al
 			{
 				approvals.GET("/queue", controllers.GetApprovalQueue)
 				approvals.POST("/:id/action", controllers.HandleApprovalAction)
+				approvals.POST("/bulk", controllers.HandleBulkApprovalAction)
 			}
 			protected.GET("/map/data",controllers.GetMapData)
+
+			jobs := protected.Group("/jobs")
+			{
+				jobs.GET("", controllers.GetJobs)
+				jobs.GET("/runs", controllers.GetJobRuns)
+				jobs.POST("/:name/run", controllers.TriggerJob)
+			}
+
+			vehicles := protected.Group("/vehicles")
+			{
+				vehicles.GET("", controllers.GetVehicles)
+				vehicles.POST("", controllers.CreateVehicle)
+				vehicles.GET("/:id", controllers.GetVehicle)
+				vehicles.PUT("/:id", controllers.UpdateVehicle)
+				vehicles.DELETE("/:id", controllers.DeleteVehicle)
+				vehicles.POST("/:id/assign", controllers.AssignVehicleDriver)
+			}
+
+			transfers := protected.Group("/transfers")
+			{
+				transfers.POST("/reject-modify", controllers.RejectAndModifyTransfer)
+				transfers.GET("/:id", controllers.GetTransfer)
+				transfers.GET("/:id/qr", controllers.GetTransferQR)
+				transfers.GET("/:id/tracking", controllers.GetTransferTracking)
+				transfers.POST("/:id/location", controllers.PostTransferLocation)
+				transfers.GET("/:id/driver-candidates", controllers.GetDriverCandidates)
+				transfers.POST("/:id/assign-driver", controllers.AssignTransferDriver)
+				transfers.POST("/:id/route", controllers.RouteTransfer)
+				transfers.POST("/:id/pickup", controllers.PickUpTransfer)
+				transfers.POST("/:id/dispatch", controllers.DispatchTransfer)
+				transfers.POST("/:id/confirm-delivery", controllers.ConfirmDelivery)
+				transfers.POST("/:id/cancel", controllers.CancelTransfer)
+				transfers.POST("/:id/fail", controllers.FailTransfer)
+				transfers.POST("/:id/temperature-log", controllers.UploadTemperatureLog)
+				transfers.GET("/:id/temperature-log", controllers.GetTemperatureLog)
+			}
+
+			consignments := protected.Group("/consignments")
+			{
+				consignments.GET("/:id", controllers.GetConsignment)
+				consignments.POST("/:id/confirm-delivery", controllers.ConfirmConsignmentDelivery)
+			}
 			protected.POST("/import/inventory", controllers.ImportInventory)
 			protected.POST("/import/admissions", controllers.ImportAdmissions)
 			
 			// api.GET("/inventory/:facility_id", controllers.GetInventory)
 			// api.GET("/items", controllers.GetAllItems)
 			
-			// // QR Code Scan
-			// api.GET("/scan", controllers.ScanQR)
+			// QR Code Scan (signed custody manifests)
+			protected.POST("/scan", controllers.ScanQR)
 
 			// // AI Solutions
 			// api.GET("/solutions/pending", controllers.GetPendingSolutions)
@@ -103,6 +162,8 @@ protected.GET("/alerts/feed", controllers.GetAlertsFeed)			// protected.GET("/al
 				reports.GET("/consumption-trend", controllers.GetConsumptionTrend)
 				reports.GET("/stockout-trend", controllers.GetStockoutPreventionTrend)
 				reports.GET("/transfer-trend", controllers.GetTransferTimeTrend)
+				reports.GET("/vehicle-performance", controllers.GetVehiclePerformance)
+				reports.GET("/leg-times", controllers.GetLegTimes)
 				reports.GET("/value-saved", controllers.GetValueSavedTrend)
 				reports.GET("/top-expired", controllers.GetTopExpiredDrugs)
 				reports.GET("/sop-violations", controllers.GetSOPViolations)
@@ -40,8 +40,6 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			fmt.Printf("🔍 DEBUG: Full Claims: %+v\n", claims)
-
 			if userID, ok := claims["user_id"].(string); ok {
 				c.Set("user_id", userID)
 			}
@@ -52,7 +50,6 @@ func AuthMiddleware() gin.HandlerFunc {
 				fmt.Println("Warning: Role claim missing or not string")
 			}
 			if district, ok := claims["district"].(string); ok {
-				fmt.Printf(" DEBUG: Setting District Context: %s\n", district)
 				c.Set("district", district)
 			} else {
 				fmt.Printf(" CRITICAL: District claim issue. Raw value: %v, Type: %T\n", claims["district"], claims["district"])
@@ -70,4 +67,36 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// streamTokenKey holds an ?access_token= taken off the request URL.
+const streamTokenKey = "stream_token"
+
+// StripAccessToken takes ?access_token= off the URL so the access log never
+// records a bearer token. It must run before the logger; only
+// StreamAuthMiddleware reads the token it keeps.
+func StripAccessToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q := c.Request.URL.Query()
+		if token := q.Get("access_token"); token != "" {
+			c.Set(streamTokenKey, token)
+			q.Del("access_token")
+			c.Request.URL.RawQuery = q.Encode()
+		}
+		c.Next()
+	}
+}
+
+// StreamAuthMiddleware authenticates EventSource connections, which cannot
+// send headers: the token may be passed as ?access_token= instead.
+func StreamAuthMiddleware() gin.HandlerFunc {
+	auth := AuthMiddleware()
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			if token := c.GetString(streamTokenKey); token != "" {
+				c.Request.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		auth(c)
+	}
+}
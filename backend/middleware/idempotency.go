@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"backend/db"
+	"backend/models"
+	"backend/services"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm/clause"
+)
+
+// IdempotencyMiddleware makes POSTs carrying an Idempotency-Key header
+// safe to retry. The first request runs and its response is stored; a
+// repeat with the same key (per user) gets that response replayed. Server
+// errors are not stored, so those can be retried for real.
+// Must run after AuthMiddleware.
+func IdempotencyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if c.Request.Method != http.MethodPost || key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key too long"})
+			return
+		}
+
+		// 1. Fingerprint the request so a reused key with a different body is caught
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Could not read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(append([]byte(c.Request.Method+" "+c.Request.URL.Path+"\n"), body...))
+		hash := hex.EncodeToString(sum[:])
+
+		userID := c.GetString("user_id")
+		database := db.GetDB()
+
+		// 2. Claim the key; if someone already has it, replay or refuse
+		record := models.IdempotencyRecord{
+			ID:          uuid.New().String(),
+			UserID:      userID,
+			Key:         key,
+			Method:      c.Request.Method,
+			Path:        c.Request.URL.Path,
+			RequestHash: hash,
+			CreatedAt:   time.Now(),
+		}
+		claimed, err := claimKey(&record)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Idempotency check failed"})
+			return
+		}
+		if !claimed {
+			var existing models.IdempotencyRecord
+			if err := database.First(&existing, "user_id = ? AND key = ?", userID, key).Error; err != nil {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "Request with this Idempotency-Key is being retried, try again"})
+				return
+			}
+			switch {
+			case existing.RequestHash != hash:
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used for a different request"})
+			case existing.StatusCode == 0:
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "Original request is still being processed"})
+			default:
+				c.Header("Idempotent-Replayed", "true")
+				c.Data(existing.StatusCode, existing.ContentType, []byte(existing.ResponseBody))
+				c.Abort()
+			}
+			return
+		}
+
+		// 3. Run the handler and keep what it wrote. A panic is answered by
+		// gin's Recovery; free the key first so it can be retried
+		writer := &capturingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		defer func() {
+			if r := recover(); r != nil {
+				database.Delete(&models.IdempotencyRecord{}, "id = ?", record.ID)
+				panic(r)
+			}
+		}()
+		c.Next()
+
+		status := writer.Status()
+		if status >= http.StatusInternalServerError {
+			database.Delete(&models.IdempotencyRecord{}, "id = ?", record.ID)
+			return
+		}
+		now := time.Now()
+		database.Model(&record).Updates(map[string]interface{}{
+			"status_code":   status,
+			"content_type":  writer.Header().Get("Content-Type"),
+			"response_body": writer.body.String(),
+			"completed_at":  now,
+		})
+	}
+}
+
+// claimKey inserts the record unless the key is taken; an expired record
+// is cleared and the key reused. Other expired records are purged by the
+// housekeeping job.
+func claimKey(record *models.IdempotencyRecord) (bool, error) {
+	database := db.GetDB()
+	database.Where("user_id = ? AND key = ? AND created_at < ?", record.UserID, record.Key, time.Now().Add(-services.IdempotencyTTL)).
+		Delete(&models.IdempotencyRecord{})
+
+	result := database.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+type capturingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *capturingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *capturingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"errors"
+	"math"
 	"strings"
 	"time"
 )
@@ -28,10 +29,11 @@ func (j *JSONMap) Scan(value interface{}) error {
 }
 
 type Batch struct {
-	BatchID    string `json:"batch_id"`
-	Quantity   int    `json:"quantity"`
-	ExpiryDate string `json:"expiry_date"`
-	MfgDate    string `json:"mfg_date"`
+	BatchID     string `json:"batch_id"`
+	Quantity    int    `json:"quantity"`
+	ExpiryDate  string `json:"expiry_date"`
+	MfgDate     string `json:"mfg_date"`
+	Quarantined bool   `json:"quarantined,omitempty"` // Held back after a cold-chain excursion
 }
 
 type BatchList []Batch
@@ -107,6 +109,11 @@ type User struct {
 	District     string  `json:"district"`
 	FacilityID   *string `json:"facility_id"`
 	PasswordHash string  `json:"-"`
+
+	// Driver availability, used by dispatch
+	OnDuty     bool   `json:"on_duty" gorm:"default:true"`
+	ShiftStart string `json:"shift_start"` // "HH:MM", empty = no shift limits
+	ShiftEnd   string `json:"shift_end"`
 }
 
 type Item struct {
@@ -115,6 +122,43 @@ type Item struct {
 	GenericName      string  `json:"generic_name"`
 	TherapeuticClass string  `json:"therapeutic_class"`
 	UnitCost         float64 `json:"unit_cost"`
+
+	StorageCondition string   `json:"storage_condition"` // AMBIENT (or empty), REFRIGERATED, FROZEN
+	MinTempC         *float64 `json:"min_temp_c"`        // Allowed range in transit; defaults by condition
+	MaxTempC         *float64 `json:"max_temp_c"`
+}
+
+// Item storage conditions
+const (
+	StorageAmbient      = "AMBIENT"
+	StorageRefrigerated = "REFRIGERATED" // 2-8 °C: insulin, most vaccines
+	StorageFrozen       = "FROZEN"
+)
+
+// NeedsColdChain reports whether the item may only travel in a
+// refrigerated vehicle.
+func (i Item) NeedsColdChain() bool {
+	return i.StorageCondition == StorageRefrigerated || i.StorageCondition == StorageFrozen
+}
+
+// TempRange is the allowed temperature in °C. Each explicit limit wins
+// over the default for the storage condition; a side with no limit at all
+// is open (±Inf). ok is false when there is no limit on either side.
+func (i Item) TempRange() (min, max float64, ok bool) {
+	min, max = math.Inf(-1), math.Inf(1)
+	switch i.StorageCondition {
+	case StorageRefrigerated:
+		min, max, ok = 2, 8, true
+	case StorageFrozen:
+		min, max, ok = -25, -15, true
+	}
+	if i.MinTempC != nil {
+		min, ok = *i.MinTempC, true
+	}
+	if i.MaxTempC != nil {
+		max, ok = *i.MaxTempC, true
+	}
+	return min, max, ok
 }
 
 type Facility struct {
@@ -130,6 +174,7 @@ type Inventory struct {
 	FacilityID       string    `json:"facility_id"`
 	ItemID           string    `json:"item_id"`
 	Quantity         int       `json:"quantity"`
+	ReservedQuantity int       `json:"reserved_quantity" gorm:"default:0"` // Promised to approved transfers, not yet picked up
 	SafetyStockLevel int       `json:"safety_stock_level"`
 	ConsumptionRate  float64   `json:"consumption_rate"`
 	Status           string    `json:"status"`
@@ -140,6 +185,29 @@ type Inventory struct {
 	Facility Facility `json:"facility" gorm:"foreignKey:FacilityID"`
 }
 
+// Available is the stock that can still be promised to new transfers.
+func (inv Inventory) Available() int {
+	return inv.Quantity - inv.ReservedQuantity - inv.Quarantined()
+}
+
+// Quarantined counts units in batches held back after a cold-chain excursion.
+func (inv Inventory) Quarantined() int {
+	n := 0
+	for _, b := range inv.BatchMetadata {
+		if b.Quarantined {
+			n += b.Quantity
+		}
+	}
+	return n
+}
+
+// TrueSurplus is what a donor can give without dipping below its safety
+// stock plus the next `days` of consumption (same rule as the ML agent).
+func (inv Inventory) TrueSurplus(days int) int {
+	minRequired := float64(inv.SafetyStockLevel) + inv.ConsumptionRate*float64(days)
+	return int(float64(inv.Available()) - minRequired)
+}
+
 type SolutionCard struct {
 	ID                 string      `json:"id" gorm:"type:uuid;primaryKey"`
 	Status             string      `json:"status"`
@@ -154,30 +222,257 @@ type SolutionCard struct {
 	ActionsRecommended StringArray `json:"actions_recommended" gorm:"type:text[]"`
 	FromFacilityID     *string     `json:"from_facility_id" gorm:"column:from_facilityid"`
 	ToFacilityID       *string     `json:"to_facility_id" gorm:"column:to_facilityid"`
+
+	// Set on cards a reviewer created by rejecting and modifying another card
+	ParentCardID *string `json:"parent_card_id" gorm:"type:uuid;index"`
+
+	// Writer-chosen dedupe key; a second insert with the same token is ignored
+	IdempotencyToken *string `json:"idempotency_token" gorm:"uniqueIndex:idx_solution_cards_idempotency_token"`
+
+	// Filled at approval; approved < proposed means a partial approval
+	ProposedQuantity *int `json:"proposed_quantity"`
+	ApprovedQuantity *int `json:"approved_quantity"`
+	// User ID of the approver, or "policy:<rule>" for auto-approvals
+	ApprovedBy *string `json:"approved_by"`
+
+	// Donor PHC's answer when the district requires donor consent
+	DonorConsent       string     `json:"donor_consent"` // "", CONSENTED, DECLINED
+	DonorConsentBy     *string    `json:"donor_consent_by"`
+	DonorConsentAt     *time.Time `json:"donor_consent_at"`
+	DonorDeclineReason string     `json:"donor_decline_reason"`
 }
 
+// Donor consent answers
+const (
+	ConsentGiven    = "CONSENTED"
+	ConsentDeclined = "DECLINED"
+)
+
+// SolutionCard statuses (lower-case, as written by the ML agent).
+const (
+	CardPending   = "pending"
+	CardApproved  = "approved"
+	CardRejected  = "rejected"
+	CardExpired   = "expired"   // Closed by the review worker: shortage resolved or donor can no longer give
+	CardCancelled = "cancelled" // Approved, but the transfer was called off before delivery
+)
+
+// Card sources
+const (
+	CardSourceAI    = "AI"
+	CardSourceHuman = "HUMAN"
+	CardSourceRules = "RULES" // Go donor finder, no ML involved
+)
+
 type Transfer struct {
 	ID                   string     `json:"id" gorm:"type:uuid;primaryKey"`
 	SolutionCardID       *string    `json:"solution_card_id"`
+	ConsignmentID        *string    `json:"consignment_id" gorm:"type:uuid;index"` // Set when this is one line of a multi-item trip
 	FromFacilityID       string     `json:"from_facility_id"`
 	ToFacilityID         string     `json:"to_facility_id"`
 	ItemID               string     `json:"item_id"`
-	Quantity             int        `json:"quantity"`
+	Quantity             int        `json:"quantity"`          // Approved quantity
+	ProposedQuantity     int        `json:"proposed_quantity"` // What the card originally asked for
+	Batches              BatchList  `json:"batches" gorm:"type:jsonb"` // FEFO-picked donor batches
 	Status               string     `json:"status"`
+	StockReserved        bool       `json:"stock_reserved" gorm:"default:false"` // Donor stock reserved at approval (pre-lifecycle rows moved stock up front)
+	ColdChain            bool       `json:"cold_chain" gorm:"default:false"`      // Item needs a refrigerated vehicle
+	TempExcursion        bool       `json:"temp_excursion" gorm:"default:false"`  // Logger showed the item out of range
 	
 	DriverID             *string    `json:"driver_id"`
+	VehicleID            *string    `json:"vehicle_id" gorm:"type:uuid"`
 	VehicleType          string     `json:"vehicle_type"`
 	VehicleNumber        string     `json:"vehicle_number"`
 	EstimatedArrivalTime *time.Time `json:"estimated_arrival_time"`
 	ActualDeliveryTime   *time.Time `json:"actual_delivery_time"`
+	PickedUpAt           *time.Time `json:"picked_up_at"`
+
+	// Proof of delivery, filled by the receiving PHC
+	ReceivedQuantity  *int   `json:"received_quantity"`
+	DeliveryCondition string `json:"delivery_condition"`
+	DeliveryNote      string `json:"delivery_note"`
+
+	// Set when the transfer is called off before delivery
+	CancelledAt  *time.Time `json:"cancelled_at"`
+	CancelledBy  string     `json:"cancelled_by"`
+	CancelReason string     `json:"cancel_reason"`
 	
 	CreatedAt            time.Time  `json:"created_at"`
 	UpdatedAt            time.Time  `json:"updated_at"`
 
 	Driver       User     `json:"driver" gorm:"foreignKey:DriverID"`
+	Vehicle      *Vehicle `json:"vehicle,omitempty" gorm:"foreignKey:VehicleID"`
 	FromFacility Facility `json:"from_facility" gorm:"foreignKey:FromFacilityID"`
 	ToFacility   Facility `json:"to_facility" gorm:"foreignKey:ToFacilityID"`
 	Item         Item     `json:"item" gorm:"foreignKey:ItemID"`
+
+	Legs []TransferLeg `json:"legs,omitempty" gorm:"foreignKey:TransferID"` // Only for hub-routed transfers
+}
+
+// TransferLeg is one hop of a transfer routed through hubs (district
+// warehouses, medical colleges). Legs run in Sequence order, each with its
+// own driver, vehicle and custody handover at its destination. The
+// transfer's status, driver and ETA follow its current leg.
+type TransferLeg struct {
+	ID                   string     `json:"id" gorm:"type:uuid;primaryKey"`
+	TransferID           string     `json:"transfer_id" gorm:"type:uuid;index"`
+	Sequence             int        `json:"sequence"` // 1-based
+	FromFacilityID       string     `json:"from_facility_id"`
+	ToFacilityID         string     `json:"to_facility_id"`
+	Status               string     `json:"status"` // Transfer states; CANCELLED/FAILED only with the transfer
+	DriverID             *string    `json:"driver_id"`
+	VehicleID            *string    `json:"vehicle_id" gorm:"type:uuid"`
+	VehicleType          string     `json:"vehicle_type"`
+	VehicleNumber        string     `json:"vehicle_number"`
+	EstimatedArrivalTime *time.Time `json:"estimated_arrival_time"`
+	PickedUpAt           *time.Time `json:"picked_up_at"`
+	HandedOverAt         *time.Time `json:"handed_over_at"` // Custody passed at ToFacility
+	HandedOverBy         string     `json:"handed_over_by"`
+	CreatedAt            time.Time  `json:"created_at"`
+	UpdatedAt            time.Time  `json:"updated_at"`
+
+	FromFacility Facility `json:"from_facility" gorm:"foreignKey:FromFacilityID"`
+	ToFacility   Facility `json:"to_facility" gorm:"foreignKey:ToFacilityID"`
+}
+
+// Consignment is one trip carrying several items between two facilities.
+// Each line is a Transfer (item, quantity, batches), so reservations, FEFO
+// picking and proof of delivery stay per item; the consignment owns the
+// driver, vehicle and status, and its lines move through the lifecycle
+// together.
+type Consignment struct {
+	ID                   string     `json:"id" gorm:"type:uuid;primaryKey"`
+	SolutionCardID       *string    `json:"solution_card_id"`
+	FromFacilityID       string     `json:"from_facility_id"`
+	ToFacilityID         string     `json:"to_facility_id"`
+	Status               string     `json:"status"`
+	DriverID             *string    `json:"driver_id"`
+	VehicleID            *string    `json:"vehicle_id" gorm:"type:uuid"`
+	VehicleType          string     `json:"vehicle_type"`
+	VehicleNumber        string     `json:"vehicle_number"`
+	EstimatedArrivalTime *time.Time `json:"estimated_arrival_time"`
+	PickedUpAt           *time.Time `json:"picked_up_at"`
+	ActualDeliveryTime   *time.Time `json:"actual_delivery_time"`
+	CancelledAt          *time.Time `json:"cancelled_at"`
+	CancelledBy          string     `json:"cancelled_by"`
+	CancelReason         string     `json:"cancel_reason"`
+	CreatedAt            time.Time  `json:"created_at"`
+	UpdatedAt            time.Time  `json:"updated_at"`
+
+	Lines        []Transfer `json:"lines" gorm:"foreignKey:ConsignmentID"`
+	FromFacility Facility   `json:"from_facility" gorm:"foreignKey:FromFacilityID"`
+	ToFacility   Facility   `json:"to_facility" gorm:"foreignKey:ToFacilityID"`
+}
+
+// ColdChain reports whether any line needs a refrigerated vehicle.
+func (c Consignment) ColdChain() bool {
+	for _, l := range c.Lines {
+		if l.ColdChain {
+			return true
+		}
+	}
+	return false
+}
+
+// TotalQuantity is the number of units across all lines.
+func (c Consignment) TotalQuantity() int {
+	total := 0
+	for _, l := range c.Lines {
+		total += l.Quantity
+	}
+	return total
+}
+
+// Transfer lifecycle states. Card approval creates a transfer in
+// APPROVED (or AWAITING_DRIVER when nobody can be dispatched); the driver
+// moves it through PICKED_UP / IN_TRANSIT and the receiving PHC closes it
+// as DELIVERED.
+const (
+	TransferPending        = "PENDING"
+	TransferAwaitingDriver = "AWAITING_DRIVER"
+	TransferApproved       = "APPROVED"
+	TransferPickedUp  = "PICKED_UP"
+	TransferInTransit = "IN_TRANSIT"
+	TransferDelivered = "DELIVERED"
+	TransferCancelled = "CANCELLED"
+	TransferFailed    = "FAILED"
+)
+
+// ActiveTransferStatuses are the states in which stock is on its way.
+var ActiveTransferStatuses = []string{TransferPickedUp, TransferInTransit}
+
+// PrePickupTransferStatuses hold reserved stock that is still at the donor.
+var PrePickupTransferStatuses = []string{TransferAwaitingDriver, TransferApproved}
+
+// DriverWorkloadStatuses count against a driver's active workload.
+var DriverWorkloadStatuses = []string{TransferApproved, TransferPickedUp, TransferInTransit}
+
+// TransferEvent is the timestamped audit row written on every status change.
+type TransferEvent struct {
+	ID          string    `json:"id" gorm:"type:uuid;primaryKey"`
+	TransferID  string    `json:"transfer_id" gorm:"index"`
+	LegSequence *int      `json:"leg_sequence,omitempty"` // Set for a step of one leg of a routed transfer
+	FromStatus  string    `json:"from_status"`
+	ToStatus    string    `json:"to_status"`
+	ActorID     string    `json:"actor_id"`
+	Note        string    `json:"note"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Vehicle types in the fleet
+const (
+	VehicleBike  = "BIKE"
+	VehicleVan   = "VAN"
+	VehicleTruck = "TRUCK"
+)
+
+// Vehicle is a registered fleet vehicle; a driver drives at most one.
+type Vehicle struct {
+	ID              string    `json:"id" gorm:"type:uuid;primaryKey"`
+	Registration    string    `json:"registration" gorm:"uniqueIndex"`
+	Type            string    `json:"type"` // BIKE, VAN, TRUCK
+	ColdChain       bool      `json:"cold_chain"`
+	PayloadCapacity int       `json:"payload_capacity"` // Max units per trip, 0 = unlimited
+	HomeFacilityID  *string   `json:"home_facility_id"`
+	DriverID        *string   `json:"driver_id" gorm:"type:uuid;index"`
+	Active          bool      `json:"active" gorm:"default:true"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// CanCarry reports whether a single trip can take qty units.
+func (v Vehicle) CanCarry(qty int) bool {
+	return v.PayloadCapacity == 0 || qty <= v.PayloadCapacity
+}
+
+// LateBy is how far a transfer is (or was) behind its ETA; zero if on time
+// or if no ETA was computed.
+func (t Transfer) LateBy(now time.Time) time.Duration {
+	if t.EstimatedArrivalTime == nil {
+		return 0
+	}
+	arrival := now
+	if t.ActualDeliveryTime != nil {
+		arrival = *t.ActualDeliveryTime
+	} else if t.Status == TransferCancelled || t.Status == TransferFailed {
+		return 0
+	}
+	if late := arrival.Sub(*t.EstimatedArrivalTime); late > 0 {
+		return late
+	}
+	return 0
+}
+
+// TemperatureReading is one sample from a data logger travelling with a
+// transfer, uploaded as CSV. Re-uploading the same export adds nothing.
+type TemperatureReading struct {
+	ID         string    `json:"id" gorm:"type:uuid;primaryKey"`
+	TransferID string    `json:"transfer_id" gorm:"type:uuid;uniqueIndex:idx_temp_reading"`
+	LoggerID   string    `json:"logger_id" gorm:"uniqueIndex:idx_temp_reading"`
+	RecordedAt time.Time `json:"recorded_at" gorm:"uniqueIndex:idx_temp_reading"`
+	TempC      float64   `json:"temp_c"`
+	UploadedBy string    `json:"uploaded_by"`
+	CreatedAt  time.Time `json:"created_at"`
 }
 
 type ComplianceLog struct {
@@ -202,7 +497,7 @@ type InventoryLog struct {
 	FacilityID  string    `json:"facility_id"`
 	ItemID      string    `json:"item_id"`
 	StockChange int       `json:"stock_change"`
-	EventType   string    `json:"event_type"` // 'consumption', 'restock'
+	EventType   string    `json:"event_type"` // 'consumption', 'restock', 'transfer_out', 'transfer_in', 'transit_loss', 'transit_damage'
 	Timestamp   time.Time `json:"timestamp"`
 }
 
@@ -212,4 +507,104 @@ type AdmissionLog struct {
 	MedicalCondition string    `json:"medical_condition"`
 	AdmissionDate    time.Time `json:"admission_date"`
 	District         string    `json:"district"`
-}
\ No newline at end of file
+}
+// LocationPing is a GPS fix sent by the driver app while a transfer is on the road.
+type LocationPing struct {
+	ID         string    `json:"id" gorm:"type:uuid;primaryKey"`
+	TransferID string    `json:"transfer_id" gorm:"index:idx_ping_transfer_time"`
+	DriverID   string    `json:"driver_id"`
+	Lat        float64   `json:"lat"`
+	Lng        float64   `json:"lng"`
+	AccuracyM  *float64  `json:"accuracy_m"`
+	SpeedKmh   *float64  `json:"speed_kmh"`
+	RecordedAt time.Time `json:"recorded_at" gorm:"index:idx_ping_transfer_time"` // Device time
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// IdempotencyRecord stores the response to a POST sent with an
+// Idempotency-Key so a retried request gets the original result back.
+type IdempotencyRecord struct {
+	ID           string     `json:"id" gorm:"type:uuid;primaryKey"`
+	UserID       string     `json:"user_id" gorm:"uniqueIndex:idx_idempotency_user_key"`
+	Key          string     `json:"key" gorm:"uniqueIndex:idx_idempotency_user_key"`
+	Method       string     `json:"method"`
+	Path         string     `json:"path"`
+	RequestHash  string     `json:"request_hash"`
+	StatusCode   int        `json:"status_code"` // 0 while the original request is still running
+	ContentType  string     `json:"content_type"`
+	ResponseBody string     `json:"response_body"`
+	CreatedAt    time.Time  `json:"created_at" gorm:"index"`
+	CompletedAt  *time.Time `json:"completed_at"`
+}
+
+// Card history actions
+const (
+	CardActionCreated   = "created"
+	CardActionApproved  = "approved"
+	CardActionRejected  = "rejected"
+	CardActionExpired   = "expired"
+	CardActionEscalated = "escalated"
+	CardActionConsented = "donor_consented"
+	CardActionDeclined  = "donor_declined"
+	CardActionCancelled = "transfer_cancelled"
+)
+
+// SolutionCardEvent is one entry in a card's history.
+type SolutionCardEvent struct {
+	ID           string    `json:"id" gorm:"type:uuid;primaryKey"`
+	CardID       string    `json:"card_id" gorm:"index"`
+	Action       string    `json:"action"`
+	FromStatus   string    `json:"from_status"`
+	ToStatus     string    `json:"to_status"`
+	FromPriority *int      `json:"from_priority"`
+	ToPriority   *int      `json:"to_priority"`
+	ActorID      string    `json:"actor_id"` // Empty for the review worker
+	Note         string    `json:"note"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Job run outcomes
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// JobRun is one execution of a scheduled job for a district ("GLOBAL" for
+// jobs that are not per district).
+type JobRun struct {
+	ID          string     `json:"id" gorm:"type:uuid;primaryKey"`
+	Job         string     `json:"job" gorm:"index:idx_job_runs_job_district"`
+	District    string     `json:"district" gorm:"index:idx_job_runs_job_district"`
+	Trigger     string     `json:"trigger"`      // "schedule" or "manual"
+	TriggeredBy string     `json:"triggered_by"` // User ID for manual runs
+	Status      string     `json:"status"`
+	StartedAt   time.Time  `json:"started_at" gorm:"index"`
+	FinishedAt  *time.Time `json:"finished_at"`
+	Summary     JSONMap    `json:"summary" gorm:"type:jsonb"`
+	Error       string     `json:"error"`
+}
+
+// Stock request statuses
+const (
+	StockRequestOpen      = "OPEN"
+	StockRequestFulfilled = "FULFILLED"
+	StockRequestCancelled = "CANCELLED"
+)
+
+// StockRequest asks the district store for stock when no facility in the
+// network can cover a projected shortfall.
+type StockRequest struct {
+	ID         string    `json:"id" gorm:"type:uuid;primaryKey"`
+	FacilityID string    `json:"facility_id" gorm:"index"`
+	ItemID     string    `json:"item_id"`
+	Quantity   int       `json:"quantity"`
+	Status     string    `json:"status"`
+	Source     string    `json:"source"` // Job that raised it, or "HUMAN"
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	Facility Facility `json:"facility" gorm:"foreignKey:FacilityID"`
+	Item     Item     `json:"item" gorm:"foreignKey:ItemID"`
+}
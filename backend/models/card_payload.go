@@ -0,0 +1,149 @@
+package models
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// CardPayloadVersion is the schema_version written by current code.
+//
+//	0  ML agent's nested shape: request_details{requestor_phc,
+//	   quantity_needed, item_requested} and recommendation{quantity,
+//	   logistics}; the donor is only in the from_facilityid column.
+//	1  flat keys (source_facility_id, item_name, quantity, ...) without a
+//	   version and often without item_id.
+//	2  CardPayload below.
+const CardPayloadVersion = 2
+
+// CardPayload is the canonical content of SolutionCard.Payload. The JSON
+// keys are the flat v1 keys, so readers of the old shape keep working.
+type CardPayload struct {
+	SchemaVersion           int     `json:"schema_version"`
+	SourceFacilityID        string  `json:"source_facility_id"`
+	SourceFacilityName      string  `json:"source_facility_name,omitempty"`
+	DestinationFacilityID   string  `json:"destination_facility_id"`
+	DestinationFacilityName string  `json:"destination_facility_name,omitempty"`
+	ItemID                  string  `json:"item_id"`
+	ItemName                string  `json:"item_name,omitempty"`
+	Quantity                int     `json:"quantity"`
+	TransportMode           string  `json:"transport_mode,omitempty"`
+	Logistics               JSONMap `json:"logistics,omitempty"` // Route details from the ML agent
+
+	// Lines, when present, is the full list for a multi-item consignment;
+	// the item fields above then repeat the first line for older readers.
+	Lines []CardLine `json:"lines,omitempty"`
+
+	// Via lists hub facility IDs to route through, in order; empty is direct.
+	Via []string `json:"via,omitempty"`
+}
+
+// CardLine is one item of a multi-item proposal.
+type CardLine struct {
+	ItemID   string `json:"item_id"`
+	ItemName string `json:"item_name,omitempty"`
+	Quantity int    `json:"quantity"`
+}
+
+// AllLines lists the proposal's items, one line for single-item cards.
+func (p CardPayload) AllLines() []CardLine {
+	if len(p.Lines) > 0 {
+		return p.Lines
+	}
+	return []CardLine{{ItemID: p.ItemID, ItemName: p.ItemName, Quantity: p.Quantity}}
+}
+
+// ParseCardPayload upgrades a card's stored payload, whatever its version,
+// to a CardPayload. Facility IDs fall back to the card columns. Nothing is
+// looked up, so ItemID or names may still be empty for old cards.
+func ParseCardPayload(card *SolutionCard) CardPayload {
+	raw := card.Payload
+	req, _ := raw["request_details"].(map[string]interface{})
+	rec, _ := raw["recommendation"].(map[string]interface{})
+
+	p := CardPayload{
+		SchemaVersion:           CardPayloadVersion,
+		SourceFacilityID:        firstString(raw["source_facility_id"]),
+		SourceFacilityName:      firstString(raw["source_facility_name"]),
+		DestinationFacilityID:   firstString(raw["destination_facility_id"], req["requestor_phc"]),
+		DestinationFacilityName: firstString(raw["destination_facility_name"]),
+		ItemID:                  firstString(raw["item_id"], req["item_id"]),
+		ItemName:                firstString(raw["item_name"], req["item_requested"], req["item_name"]),
+		Quantity:                firstInt(raw["quantity"], rec["quantity"], req["quantity_needed"]),
+		TransportMode:           strings.ToUpper(firstString(raw["transport_mode"], rec["transport_mode"])),
+	}
+	if l, ok := raw["logistics"].(map[string]interface{}); ok {
+		p.Logistics = l
+	} else if l, ok := rec["logistics"].(map[string]interface{}); ok {
+		p.Logistics = l
+	}
+
+	if lines, ok := raw["lines"].([]interface{}); ok {
+		for _, l := range lines {
+			if m, ok := l.(map[string]interface{}); ok {
+				p.Lines = append(p.Lines, CardLine{
+					ItemID:   firstString(m["item_id"]),
+					ItemName: firstString(m["item_name"]),
+					Quantity: firstInt(m["quantity"]),
+				})
+			}
+		}
+	}
+
+	if via, ok := raw["via"].([]interface{}); ok {
+		for _, v := range via {
+			if id := firstString(v); id != "" {
+				p.Via = append(p.Via, id)
+			}
+		}
+	}
+
+	if p.SourceFacilityID == "" && card.FromFacilityID != nil {
+		p.SourceFacilityID = *card.FromFacilityID
+	}
+	if p.DestinationFacilityID == "" && card.ToFacilityID != nil {
+		p.DestinationFacilityID = *card.ToFacilityID
+	}
+	return p
+}
+
+// Map converts the payload to the JSONMap stored on the card.
+func (p CardPayload) Map() JSONMap {
+	p.SchemaVersion = CardPayloadVersion
+	if len(p.Lines) > 0 {
+		p.ItemID, p.ItemName, p.Quantity = p.Lines[0].ItemID, p.Lines[0].ItemName, p.Lines[0].Quantity
+	}
+	raw, _ := json.Marshal(p)
+	m := JSONMap{}
+	json.Unmarshal(raw, &m)
+	return m
+}
+
+func firstString(values ...interface{}) string {
+	for _, v := range values {
+		if s, ok := v.(string); ok && strings.TrimSpace(s) != "" {
+			return strings.TrimSpace(s)
+		}
+	}
+	return ""
+}
+
+// firstInt accepts JSON numbers and numeric strings; the ML agent has
+// written both.
+func firstInt(values ...interface{}) int {
+	for _, v := range values {
+		var i int
+		switch n := v.(type) {
+		case float64:
+			i = int(n)
+		case int:
+			i = n
+		case string:
+			i, _ = strconv.Atoi(strings.TrimSpace(n))
+		}
+		if i > 0 {
+			return i
+		}
+	}
+	return 0
+}
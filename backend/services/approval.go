@@ -0,0 +1,282 @@
+package services
+
+import (
+	"backend/models"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ApprovalInput identifies who is approving a card and, optionally, a
+// smaller quantity than the card proposed.
+type ApprovalInput struct {
+	ActorID  string
+	Role     string
+	District string // The DHO's; the card's recipient must be in it
+	Quantity int    // 0 approves the full proposed quantity
+	ByPolicy bool   // Set only by the auto-approval sweep, never from a request
+}
+
+// ApprovalResult is the response shape shared by every approval endpoint.
+type ApprovalResult struct {
+	Status         string `json:"status"`
+	Message        string `json:"message"`
+	CardID         string `json:"card_id"`
+	TransferID     string `json:"transfer_id,omitempty"`
+	ConsignmentID  string `json:"consignment_id,omitempty"` // Multi-item cards; TransferID is then its first line
+	TransferStatus string `json:"transfer_status,omitempty"`
+	DriverAssigned string `json:"driver_assigned,omitempty"`
+
+	ProposedQuantity int `json:"proposed_quantity,omitempty"`
+	ApprovedQuantity int `json:"approved_quantity,omitempty"`
+}
+
+// ApproveCard approves a pending SolutionCard: it reserves donor stock,
+// dispatches a driver and creates the Transfer. It must run inside a
+// transaction.
+func ApproveCard(tx *gorm.DB, cardID string, in ApprovalInput) (*ApprovalResult, error) {
+	card, err := lockPendingCard(tx, cardID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Final sign-off is the DHO's (or a DHO-configured policy's), after any earlier steps
+	if in.Role != RoleDHO && !in.ByPolicy {
+		return nil, fmt.Errorf("%w: only the DHO can approve a transfer", ErrForbidden)
+	}
+	if !in.ByPolicy {
+		if err := checkCardDistrict(tx, card, in.District); err != nil {
+			return nil, err
+		}
+	}
+	if step := PendingStep(tx, card); step != StepDHO {
+		return nil, fmt.Errorf("%w: card is awaiting %s", ErrInvalidTransition, step)
+	}
+
+	spec, err := ResolveTransferSpec(tx, card)
+	if err != nil {
+		return nil, err
+	}
+	if spec.MultiLine() {
+		return approveConsignmentCard(tx, card, spec, in)
+	}
+
+	proposed := spec.Quantity
+	approved, err := approvedQuantity(spec, in.Quantity)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1. Reserve Donor Stock (deducted at pickup, credited on delivery)
+	if err := ReserveStock(tx, spec.FromFacilityID, spec.ItemID, approved); err != nil {
+		return nil, err
+	}
+
+	// 2. Pick Batches First-Expiry-First-Out
+	batches, err := pickTransferBatches(tx, spec.FromFacilityID, spec.ItemID, approved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pick batches: %w", err)
+	}
+
+	// 3. Dispatch a Driver (none free -> transfer waits in AWAITING_DRIVER);
+	// cold-chain items only go in refrigerated vehicles
+	coldChain := len(coldChainItems(tx, spec.ItemID)) > 0
+	driver, err := SelectDriver(tx, spec.FromFacilityID, approved, coldChain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dispatch driver: %w", err)
+	}
+	status := models.TransferApproved
+	if driver == nil {
+		status = models.TransferAwaitingDriver
+	}
+
+	// 4. Create Transfer Record
+	now := time.Now()
+	transfer := models.Transfer{
+		ID:               uuid.New().String(),
+		SolutionCardID:   &card.ID,
+		FromFacilityID:   spec.FromFacilityID,
+		ToFacilityID:     spec.ToFacilityID,
+		ItemID:           spec.ItemID,
+		Quantity:         approved,
+		ProposedQuantity: proposed,
+		Batches:          batches,
+		Status:           status,
+		StockReserved:    true,
+		ColdChain:        coldChain,
+		VehicleType:      spec.VehicleType, // Requested mode until a vehicle is dispatched
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	driverEmail := ""
+	if driver != nil {
+		transfer.DriverID = &driver.DriverID
+		transfer.VehicleID = &driver.VehicleID
+		transfer.VehicleType = driver.VehicleType
+		transfer.VehicleNumber = driver.Registration
+		driverEmail = driver.Email
+	}
+	transfer.EstimatedArrivalTime = EstimateArrivalAtApproval(tx, &transfer, now)
+	if err := tx.Create(&transfer).Error; err != nil {
+		return nil, fmt.Errorf("failed to create transfer: %w", err)
+	}
+	if err := RecordTransferCreated(tx, &transfer, in.ActorID); err != nil {
+		return nil, fmt.Errorf("failed to create transfer: %w", err)
+	}
+
+	// 5. Route through hubs when the card asks for it
+	if len(spec.Via) > 0 {
+		routed, err := RouteTransfer(tx, transfer.ID, spec.Via, in.ActorID)
+		if err != nil {
+			return nil, err
+		}
+		transfer = *routed
+	}
+
+	// 6. Close the Card
+	if err := closeApprovedCard(tx, card, proposed, approved, in.ActorID,
+		fmt.Sprintf("Approved %d of %d units; transfer %s", approved, proposed, transfer.ID)); err != nil {
+		return nil, err
+	}
+
+	return &ApprovalResult{
+		Status:           "success",
+		Message:          "Transfer approved",
+		CardID:           card.ID,
+		TransferID:       transfer.ID,
+		TransferStatus:   transfer.Status,
+		DriverAssigned:   driverEmail,
+		ProposedQuantity: proposed,
+		ApprovedQuantity: approved,
+	}, nil
+}
+
+// approveConsignmentCard approves a multi-item card as one consignment.
+// Lines are approved in full; partial quantities are for single-item cards.
+func approveConsignmentCard(tx *gorm.DB, card *models.SolutionCard, spec *TransferSpec, in ApprovalInput) (*ApprovalResult, error) {
+	if in.Quantity != 0 {
+		return nil, fmt.Errorf("%w: a multi-item card is approved as proposed", ErrInvalidInput)
+	}
+	c, driver, err := createConsignment(tx, card, spec, in.ActorID)
+	if err != nil {
+		return nil, err
+	}
+	total := c.TotalQuantity()
+	if err := closeApprovedCard(tx, card, total, total, in.ActorID,
+		fmt.Sprintf("Approved %d items, %d units; consignment %s", len(c.Lines), total, c.ID)); err != nil {
+		return nil, err
+	}
+
+	result := &ApprovalResult{
+		Status:           "success",
+		Message:          "Consignment approved",
+		CardID:           card.ID,
+		TransferID:       c.Lines[0].ID,
+		ConsignmentID:    c.ID,
+		TransferStatus:   c.Status,
+		ProposedQuantity: total,
+		ApprovedQuantity: total,
+	}
+	if driver != nil {
+		result.DriverAssigned = driver.Email
+	}
+	return result, nil
+}
+
+func closeApprovedCard(tx *gorm.DB, card *models.SolutionCard, proposed, approved int, actorID, note string) error {
+	if err := tx.Model(card).Updates(map[string]interface{}{
+		"status":            models.CardApproved,
+		"proposed_quantity": proposed,
+		"approved_quantity": approved,
+		"approved_by":       actorID,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to update card status: %w", err)
+	}
+	return RecordCardEvent(tx, card.ID, CardChange{
+		Action:     models.CardActionApproved,
+		FromStatus: models.CardPending,
+		ToStatus:   models.CardApproved,
+		ActorID:    actorID,
+		Note:       note,
+	})
+}
+
+// approvedQuantity validates a reviewer's quantity override (0 approves
+// as proposed). A partial approval must stay within what was proposed;
+// ReserveStock then holds every approval to the donor's safety stock.
+func approvedQuantity(spec *TransferSpec, requested int) (int, error) {
+	if requested == 0 {
+		requested = spec.Quantity
+	}
+	if requested < 0 || requested > spec.Quantity {
+		return 0, fmt.Errorf("%w: approved quantity must be between 1 and %d", ErrInvalidInput, spec.Quantity)
+	}
+	return requested, nil
+}
+
+// RejectionInput identifies who is rejecting a card.
+type RejectionInput struct {
+	ActorID  string
+	Role     string
+	District string
+}
+
+// RejectCard marks a pending SolutionCard as rejected.
+func RejectCard(tx *gorm.DB, cardID string, in RejectionInput) (*ApprovalResult, error) {
+	card, err := lockPendingCard(tx, cardID)
+	if err != nil {
+		return nil, err
+	}
+	// Donor staff decline through RespondAsDonor; the DHO can reject at any step
+	if in.Role != RoleDHO {
+		return nil, fmt.Errorf("%w: only the DHO can reject a transfer", ErrForbidden)
+	}
+	if err := checkCardDistrict(tx, card, in.District); err != nil {
+		return nil, err
+	}
+	if err := tx.Model(card).Update("status", models.CardRejected).Error; err != nil {
+		return nil, fmt.Errorf("failed to update card status: %w", err)
+	}
+	if err := RecordCardEvent(tx, card.ID, CardChange{
+		Action:     models.CardActionRejected,
+		FromStatus: models.CardPending,
+		ToStatus:   models.CardRejected,
+		ActorID:    in.ActorID,
+	}); err != nil {
+		return nil, err
+	}
+	return &ApprovalResult{
+		Status:  "success",
+		Message: "Transfer rejected",
+		CardID:  card.ID,
+	}, nil
+}
+
+// checkCardDistrict refuses a DHO decision on a card whose recipient is
+// outside the DHO's district, as the approval queue would not list it.
+func checkCardDistrict(tx *gorm.DB, card *models.SolutionCard, district string) error {
+	to := NormalizeCardPayload(tx, card).DestinationFacilityID
+	if district == "" || to == "" || facilityDistrict(tx, to) != district {
+		return fmt.Errorf("%w: the card's recipient is outside your district", ErrForbidden)
+	}
+	return nil
+}
+
+// lockPendingCard loads a card FOR UPDATE so double clicks cannot approve twice.
+func lockPendingCard(tx *gorm.DB, cardID string) (*models.SolutionCard, error) {
+	var card models.SolutionCard
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&card, "id = ?", cardID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: card %s", ErrNotFound, cardID)
+		}
+		return nil, err
+	}
+	if card.Status != models.CardPending {
+		return nil, fmt.Errorf("%w: card is %s", ErrAlreadyProcessed, card.Status)
+	}
+	return &card, nil
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"backend/models"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const batchDateLayout = "2006-01-02"
+
+// PickFEFO picks up to qty units from batches, first-expiry-first-out.
+// Expired and quarantined batches are never picked; batches without a
+// readable expiry go last. The result may total less than qty when the batch list does not
+// cover the aggregate quantity.
+func PickFEFO(batches models.BatchList, qty int) models.BatchList {
+	today := time.Now().Truncate(24 * time.Hour)
+
+	type candidate struct {
+		batch  models.Batch
+		expiry time.Time
+		dated  bool
+	}
+	var candidates []candidate
+	for _, b := range batches {
+		if b.Quantity <= 0 || b.Quarantined {
+			continue
+		}
+		expiry, err := time.Parse(batchDateLayout, b.ExpiryDate)
+		if err == nil && expiry.Before(today) {
+			continue
+		}
+		candidates = append(candidates, candidate{batch: b, expiry: expiry, dated: err == nil})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].dated != candidates[j].dated {
+			return candidates[i].dated
+		}
+		return candidates[i].expiry.Before(candidates[j].expiry)
+	})
+
+	picked := make(models.BatchList, 0)
+	for _, c := range candidates {
+		if qty <= 0 {
+			break
+		}
+		take := c.batch.Quantity
+		if take > qty {
+			take = qty
+		}
+		b := c.batch
+		b.Quantity = take
+		picked = append(picked, b)
+		qty -= take
+	}
+	return picked
+}
+
+// batchKey tells batch entries apart: units of a batch held back in
+// quarantine are kept as their own entry, next to the usable ones.
+type batchKey struct {
+	id          string
+	quarantined bool
+}
+
+func keyOf(b models.Batch) batchKey {
+	return batchKey{b.BatchID, b.Quarantined}
+}
+
+// SubtractBatches removes the picked quantities from a batch list,
+// dropping batches that reach zero. Usable and quarantined units of the
+// same batch are separate entries and only match their own kind.
+func SubtractBatches(from, picked models.BatchList) models.BatchList {
+	taken := make(map[batchKey]int, len(picked))
+	for _, p := range picked {
+		taken[keyOf(p)] += p.Quantity
+	}
+	result := make(models.BatchList, 0, len(from))
+	for _, b := range from {
+		if n := taken[keyOf(b)]; n > 0 {
+			use := n
+			if use > b.Quantity {
+				use = b.Quantity
+			}
+			b.Quantity -= use
+			taken[keyOf(b)] -= use
+		}
+		if b.Quantity > 0 {
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
+// MergeBatches adds batches into a list, topping up entries already
+// present for the same batch and quarantine state.
+func MergeBatches(into, add models.BatchList) models.BatchList {
+	result := append(make(models.BatchList, 0, len(into)+len(add)), into...)
+	for _, a := range add {
+		merged := false
+		for i := range result {
+			if keyOf(result[i]) == keyOf(a) {
+				result[i].Quantity += a.Quantity
+				if result[i].ExpiryDate == "" {
+					result[i].ExpiryDate = a.ExpiryDate
+				}
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+// MarkQuarantined moves the suspect quantities of a batch list from its
+// usable entries to quarantined ones, as far as the list still holds them.
+func MarkQuarantined(list, suspect models.BatchList) models.BatchList {
+	held := map[string]int{}
+	for _, b := range list {
+		if !b.Quarantined {
+			held[b.BatchID] += b.Quantity
+		}
+	}
+	var moved models.BatchList
+	for _, s := range suspect {
+		n := s.Quantity
+		if n > held[s.BatchID] {
+			n = held[s.BatchID]
+		}
+		if n <= 0 {
+			continue
+		}
+		held[s.BatchID] -= n
+		b := s
+		b.Quantity, b.Quarantined = n, false
+		moved = append(moved, b)
+	}
+	list = SubtractBatches(list, moved)
+	for i := range moved {
+		moved[i].Quarantined = true
+	}
+	return MergeBatches(list, moved)
+}
+
+// SumBatches totals the units in a batch list.
+func SumBatches(batches models.BatchList) int {
+	total := 0
+	for _, b := range batches {
+		total += b.Quantity
+	}
+	return total
+}
+
+// pickTransferBatches chooses FEFO batches for a new transfer from the
+// donor's batches that are not already promised to other approved
+// transfers still waiting for pickup. A donor that tracks batches must
+// cover the whole quantity from usable ones, so Transfer.Batches always
+// adds up to Quantity; stock without batch records is moved untracked.
+func pickTransferBatches(tx *gorm.DB, facilityID, itemID string, qty int) (models.BatchList, error) {
+	var inv models.Inventory
+	if err := tx.Select("batch_metadata").
+		Where("facility_id = ? AND item_id = ?", facilityID, itemID).
+		First(&inv).Error; err != nil {
+		return nil, err
+	}
+
+	var open []models.Transfer
+	if err := tx.Select("batches").
+		Where("from_facility_id = ? AND item_id = ? AND status IN ?", facilityID, itemID, models.PrePickupTransferStatuses).
+		Find(&open).Error; err != nil {
+		return nil, err
+	}
+
+	if len(inv.BatchMetadata) == 0 {
+		return models.BatchList{}, nil
+	}
+	available := inv.BatchMetadata
+	for _, t := range open {
+		available = SubtractBatches(available, t.Batches)
+	}
+	picked := PickFEFO(available, qty)
+	if got := SumBatches(picked); got < qty {
+		return nil, fmt.Errorf("%w: only %d of %d units are in unexpired, unpromised batches", ErrInsufficientStock, got, qty)
+	}
+	return picked, nil
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"backend/models"
+	"reflect"
+	"testing"
+)
+
+func TestPickFEFO(t *testing.T) {
+	stock := models.BatchList{
+		{BatchID: "LATE", Quantity: 50, ExpiryDate: "2099-06-30"},
+		{BatchID: "UNDATED", Quantity: 40},
+		{BatchID: "EXPIRED", Quantity: 30, ExpiryDate: "2000-01-01"},
+		{BatchID: "SOON", Quantity: 20, ExpiryDate: "2098-01-31"},
+		{BatchID: "HELD", Quantity: 100, ExpiryDate: "2090-01-01", Quarantined: true},
+		{BatchID: "EMPTY", Quantity: 0, ExpiryDate: "2091-01-01"},
+	}
+	cases := []struct {
+		name string
+		qty  int
+		want models.BatchList
+	}{
+		{"earliest expiry first", 15, models.BatchList{
+			{BatchID: "SOON", Quantity: 15, ExpiryDate: "2098-01-31"},
+		}},
+		{"spills into the next batch", 30, models.BatchList{
+			{BatchID: "SOON", Quantity: 20, ExpiryDate: "2098-01-31"},
+			{BatchID: "LATE", Quantity: 10, ExpiryDate: "2099-06-30"},
+		}},
+		{"undated batches go last", 80, models.BatchList{
+			{BatchID: "SOON", Quantity: 20, ExpiryDate: "2098-01-31"},
+			{BatchID: "LATE", Quantity: 50, ExpiryDate: "2099-06-30"},
+			{BatchID: "UNDATED", Quantity: 10},
+		}},
+		{"short when usable stock runs out", 500, models.BatchList{
+			{BatchID: "SOON", Quantity: 20, ExpiryDate: "2098-01-31"},
+			{BatchID: "LATE", Quantity: 50, ExpiryDate: "2099-06-30"},
+			{BatchID: "UNDATED", Quantity: 40},
+		}},
+		{"nothing asked", 0, models.BatchList{}},
+	}
+	for _, c := range cases {
+		if got := PickFEFO(stock, c.qty); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: PickFEFO(%d) = %+v, want %+v", c.name, c.qty, got, c.want)
+		}
+	}
+}
+
+func TestSubtractBatches(t *testing.T) {
+	cases := []struct {
+		name         string
+		from, picked models.BatchList
+		want         models.BatchList
+	}{
+		{"partial",
+			models.BatchList{{BatchID: "A", Quantity: 10}, {BatchID: "B", Quantity: 5}},
+			models.BatchList{{BatchID: "A", Quantity: 4}},
+			models.BatchList{{BatchID: "A", Quantity: 6}, {BatchID: "B", Quantity: 5}}},
+		{"emptied batches are dropped",
+			models.BatchList{{BatchID: "A", Quantity: 10}, {BatchID: "B", Quantity: 5}},
+			models.BatchList{{BatchID: "B", Quantity: 5}},
+			models.BatchList{{BatchID: "A", Quantity: 10}}},
+		{"never below zero",
+			models.BatchList{{BatchID: "A", Quantity: 3}},
+			models.BatchList{{BatchID: "A", Quantity: 7}},
+			models.BatchList{}},
+		{"unknown batches are ignored",
+			models.BatchList{{BatchID: "A", Quantity: 3}},
+			models.BatchList{{BatchID: "Z", Quantity: 3}},
+			models.BatchList{{BatchID: "A", Quantity: 3}}},
+		{"usable units leave quarantined ones alone",
+			models.BatchList{{BatchID: "A", Quantity: 4, Quarantined: true}, {BatchID: "A", Quantity: 6}},
+			models.BatchList{{BatchID: "A", Quantity: 8}},
+			models.BatchList{{BatchID: "A", Quantity: 4, Quarantined: true}}},
+	}
+	for _, c := range cases {
+		if got := SubtractBatches(c.from, c.picked); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: SubtractBatches = %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestMergeBatches(t *testing.T) {
+	cases := []struct {
+		name      string
+		into, add models.BatchList
+		want      models.BatchList
+	}{
+		{"tops up an existing batch",
+			models.BatchList{{BatchID: "A", Quantity: 10, ExpiryDate: "2099-01-01"}},
+			models.BatchList{{BatchID: "A", Quantity: 5, ExpiryDate: "2099-01-01"}},
+			models.BatchList{{BatchID: "A", Quantity: 15, ExpiryDate: "2099-01-01"}}},
+		{"appends a new batch",
+			models.BatchList{{BatchID: "A", Quantity: 10}},
+			models.BatchList{{BatchID: "B", Quantity: 5, ExpiryDate: "2099-01-01"}},
+			models.BatchList{{BatchID: "A", Quantity: 10}, {BatchID: "B", Quantity: 5, ExpiryDate: "2099-01-01"}}},
+		{"fills a missing expiry",
+			models.BatchList{{BatchID: "A", Quantity: 10}},
+			models.BatchList{{BatchID: "A", Quantity: 5, ExpiryDate: "2099-01-01"}},
+			models.BatchList{{BatchID: "A", Quantity: 15, ExpiryDate: "2099-01-01"}}},
+		{"usable units do not join a quarantined entry",
+			models.BatchList{{BatchID: "A", Quantity: 4, Quarantined: true}},
+			models.BatchList{{BatchID: "A", Quantity: 6}},
+			models.BatchList{{BatchID: "A", Quantity: 4, Quarantined: true}, {BatchID: "A", Quantity: 6}}},
+	}
+	for _, c := range cases {
+		if got := MergeBatches(c.into, c.add); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: MergeBatches = %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestMergeBatchesLeavesInputAlone(t *testing.T) {
+	into := models.BatchList{{BatchID: "A", Quantity: 10}}
+	MergeBatches(into, models.BatchList{{BatchID: "A", Quantity: 5}})
+	if into[0].Quantity != 10 {
+		t.Errorf("MergeBatches changed its input: %+v", into)
+	}
+}
+
+func TestMarkQuarantined(t *testing.T) {
+	cases := []struct {
+		name          string
+		list, suspect models.BatchList
+		want          models.BatchList
+	}{
+		{"only the delivered units are held",
+			models.BatchList{{BatchID: "A", Quantity: 30, ExpiryDate: "2099-01-01"}},
+			models.BatchList{{BatchID: "A", Quantity: 10, ExpiryDate: "2099-01-01"}},
+			models.BatchList{{BatchID: "A", Quantity: 20, ExpiryDate: "2099-01-01"}, {BatchID: "A", Quantity: 10, ExpiryDate: "2099-01-01", Quarantined: true}}},
+		{"whole batch",
+			models.BatchList{{BatchID: "A", Quantity: 10}, {BatchID: "B", Quantity: 5}},
+			models.BatchList{{BatchID: "A", Quantity: 10}},
+			models.BatchList{{BatchID: "B", Quantity: 5}, {BatchID: "A", Quantity: 10, Quarantined: true}}},
+		{"no more than is left",
+			models.BatchList{{BatchID: "A", Quantity: 4}},
+			models.BatchList{{BatchID: "A", Quantity: 10}},
+			models.BatchList{{BatchID: "A", Quantity: 4, Quarantined: true}}},
+		{"joins units already held",
+			models.BatchList{{BatchID: "A", Quantity: 3, Quarantined: true}, {BatchID: "A", Quantity: 7}},
+			models.BatchList{{BatchID: "A", Quantity: 2}},
+			models.BatchList{{BatchID: "A", Quantity: 5, Quarantined: true}, {BatchID: "A", Quantity: 5}}},
+		{"batches no longer held are skipped",
+			models.BatchList{{BatchID: "A", Quantity: 4}},
+			models.BatchList{{BatchID: "Z", Quantity: 10}},
+			models.BatchList{{BatchID: "A", Quantity: 4}}},
+	}
+	for _, c := range cases {
+		if got := MarkQuarantined(c.list, c.suspect); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: MarkQuarantined = %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSumBatches(t *testing.T) {
+	if got := SumBatches(models.BatchList{{Quantity: 3}, {Quantity: 4}}); got != 7 {
+		t.Errorf("SumBatches = %d, want 7", got)
+	}
+	if got := SumBatches(nil); got != 0 {
+		t.Errorf("SumBatches(nil) = %d, want 0", got)
+	}
+}
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"backend/models"
+	"errors"
+	"fmt"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+// Bulk actions on the approval queue
+const (
+	BulkApprove = "approve"
+	BulkReject  = "reject"
+)
+
+// Per-card outcomes of a bulk action
+const (
+	OutcomeSuccess           = "success"
+	OutcomeInsufficientStock = "insufficient_stock"
+	OutcomeAlreadyProcessed  = "already_processed"
+	OutcomeNotFound          = "not_found"
+	OutcomeSurplusConflict   = "surplus_conflict"
+	OutcomeInvalid           = "invalid"
+	OutcomeForbidden         = "forbidden"
+	OutcomeError             = "error"
+)
+
+// MaxBulkCards caps one bulk request.
+const MaxBulkCards = 100
+
+// BulkOutcome is the result for one card.
+type BulkOutcome struct {
+	CardID  string          `json:"card_id"`
+	Result  string          `json:"result"`
+	Message string          `json:"message,omitempty"`
+	Detail  *ApprovalResult `json:"detail,omitempty"`
+}
+
+// DonorConflict flags selected cards that draw on the same donor item and
+// together ask for more than its true surplus.
+type DonorConflict struct {
+	DonorFacilityID string   `json:"donor_facility_id"`
+	ItemID          string   `json:"item_id"`
+	CardIDs         []string `json:"card_ids"`
+	Requested       int      `json:"requested"`
+	TrueSurplus     int      `json:"true_surplus"`
+}
+
+// BulkResult is the response of a bulk action.
+type BulkResult struct {
+	Action    string          `json:"action"`
+	Succeeded int             `json:"succeeded"`
+	Failed    int             `json:"failed"`
+	Results   []BulkOutcome   `json:"results"`
+	Conflicts []DonorConflict `json:"conflicts"`
+}
+
+// BulkDecide approves or rejects cards in the order given. Each card runs
+// in its own transaction so one failure does not undo the others; db must
+// therefore not be a transaction itself.
+//
+// For approvals, cards that share a donor item are checked against its
+// true surplus first. Within such a group cards are approved in order
+// until the surplus is used up; the rest are skipped as surplus_conflict
+// and stay pending for individual review.
+func BulkDecide(db *gorm.DB, cardIDs []string, action string, actor ApprovalInput) (*BulkResult, error) {
+	if action != BulkApprove && action != BulkReject {
+		return nil, fmt.Errorf("%w: unknown action %q", ErrInvalidInput, action)
+	}
+	if len(cardIDs) == 0 || len(cardIDs) > MaxBulkCards {
+		return nil, fmt.Errorf("%w: between 1 and %d card IDs are required", ErrInvalidInput, MaxBulkCards)
+	}
+
+	result := &BulkResult{Action: action, Results: []BulkOutcome{}, Conflicts: []DonorConflict{}}
+
+	// Budget per conflicting donor item (approvals only)
+	budget := map[donorItem]int{}
+	need := map[string]map[donorItem]int{}
+	if action == BulkApprove {
+		var err error
+		result.Conflicts, need, err = findDonorConflicts(db, cardIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range result.Conflicts {
+			budget[donorItem{c.DonorFacilityID, c.ItemID}] = c.TrueSurplus
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, id := range cardIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		if msg := overBudget(need[id], budget); msg != "" {
+			result.add(BulkOutcome{CardID: id, Result: OutcomeSurplusConflict, Message: msg})
+			continue
+		}
+
+		var detail *ApprovalResult
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if action == BulkApprove {
+				detail, err = ApproveCard(tx, id, ApprovalInput{ActorID: actor.ActorID, Role: actor.Role, District: actor.District})
+			} else {
+				detail, err = RejectCard(tx, id, RejectionInput{ActorID: actor.ActorID, Role: actor.Role, District: actor.District})
+			}
+			return err
+		})
+		if err != nil {
+			result.add(BulkOutcome{CardID: id, Result: outcomeOf(err), Message: err.Error()})
+			continue
+		}
+		for group, qty := range need[id] {
+			if _, limited := budget[group]; limited {
+				budget[group] -= qty
+			}
+		}
+		result.add(BulkOutcome{CardID: id, Result: OutcomeSuccess, Detail: detail})
+	}
+	return result, nil
+}
+
+func (r *BulkResult) add(o BulkOutcome) {
+	if o.Result == OutcomeSuccess {
+		r.Succeeded++
+	} else {
+		r.Failed++
+	}
+	r.Results = append(r.Results, o)
+}
+
+func outcomeOf(err error) string {
+	switch {
+	case errors.Is(err, ErrInsufficientStock):
+		return OutcomeInsufficientStock
+	case errors.Is(err, ErrAlreadyProcessed):
+		return OutcomeAlreadyProcessed
+	case errors.Is(err, ErrNotFound):
+		return OutcomeNotFound
+	case errors.Is(err, ErrInvalidInput), errors.Is(err, ErrInvalidTransition):
+		return OutcomeInvalid
+	case errors.Is(err, ErrForbidden):
+		return OutcomeForbidden
+	default:
+		return OutcomeError
+	}
+}
+
+type donorItem struct {
+	FacilityID string
+	ItemID     string
+}
+
+// overBudget explains why a card's lines no longer fit the remaining
+// surplus of a conflicting donor item, or returns "".
+func overBudget(lines map[donorItem]int, budget map[donorItem]int) string {
+	for group, qty := range lines {
+		if left, limited := budget[group]; limited && qty > left {
+			return fmt.Sprintf("Needs %d of item %s but only %d of the donor's true surplus is left after earlier cards", qty, group.ItemID, left)
+		}
+	}
+	return ""
+}
+
+// findDonorConflicts groups the pending cards' lines by donor item and
+// returns the groups of two or more cards whose combined quantity exceeds
+// the donor's true surplus, plus what each card needs per donor item.
+func findDonorConflicts(tx *gorm.DB, cardIDs []string) ([]DonorConflict, map[string]map[donorItem]int, error) {
+	var cards []models.SolutionCard
+	if err := tx.Where("id IN ? AND status = ?", cardIDs, models.CardPending).Find(&cards).Error; err != nil {
+		return nil, nil, err
+	}
+
+	need := map[string]map[donorItem]int{}
+	members := map[donorItem][]string{}
+	for i := range cards {
+		spec, err := ResolveTransferSpec(tx, &cards[i])
+		if err != nil {
+			continue // Reported per card when it is processed
+		}
+		need[cards[i].ID] = map[donorItem]int{}
+		for _, l := range spec.Lines {
+			key := donorItem{spec.FromFacilityID, l.ItemID}
+			need[cards[i].ID][key] = l.Quantity
+			members[key] = append(members[key], cards[i].ID)
+		}
+	}
+
+	conflicts := []DonorConflict{}
+	for key, ids := range members {
+		if len(ids) < 2 {
+			continue
+		}
+		requested := 0
+		for _, id := range ids {
+			requested += need[id][key]
+		}
+		var inv models.Inventory
+		if err := tx.Where("facility_id = ? AND item_id = ?", key.FacilityID, key.ItemID).First(&inv).Error; err != nil {
+			continue
+		}
+		surplus := inv.TrueSurplus(SurplusForecastDays)
+		if surplus < 0 {
+			surplus = 0
+		}
+		if requested > surplus {
+			// Report cards in request order
+			sort.SliceStable(ids, func(i, j int) bool { return indexOf(cardIDs, ids[i]) < indexOf(cardIDs, ids[j]) })
+			conflicts = append(conflicts, DonorConflict{
+				DonorFacilityID: key.FacilityID,
+				ItemID:          key.ItemID,
+				CardIDs:         ids,
+				Requested:       requested,
+				TrueSurplus:     surplus,
+			})
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].DonorFacilityID+conflicts[i].ItemID < conflicts[j].DonorFacilityID+conflicts[j].ItemID
+	})
+	return conflicts, need, nil
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return len(list)
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"backend/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CancelInput is who is calling a transfer off and why.
+type CancelInput struct {
+	ActorID    string
+	Role       string
+	FacilityID string
+	Reason     string
+}
+
+// CancelTransfer calls off a transfer at any point before delivery. The
+// lifecycle rolls the stock back (a reservation is released, stock already
+// picked up returns to the donor with its batches), the transfer records
+// who cancelled it and why, and the card it came from is marked cancelled.
+// A consignment line cancels the whole consignment. Only the DHO or staff
+// of the donor or recipient facility may cancel.
+func CancelTransfer(tx *gorm.DB, transferID string, in CancelInput) (*models.Transfer, error) {
+	in.Reason = strings.TrimSpace(in.Reason)
+	if in.Reason == "" {
+		return nil, fmt.Errorf("%w: a reason is required to cancel a transfer", ErrInvalidInput)
+	}
+
+	var head models.Transfer
+	if err := tx.Select("id, from_facility_id, to_facility_id, solution_card_id").First(&head, "id = ?", transferID).Error; err != nil {
+		return nil, fmt.Errorf("%w: transfer %s", ErrNotFound, transferID)
+	}
+	if in.Role != RoleDHO && (in.FacilityID == "" || (in.FacilityID != head.FromFacilityID && in.FacilityID != head.ToFacilityID)) {
+		return nil, fmt.Errorf("%w: only the DHO or the donor or recipient facility can cancel this transfer", ErrForbidden)
+	}
+
+	transfer, err := TransitionTransfer(tx, transferID, models.TransferCancelled, TransitionInput{
+		ActorID: in.ActorID,
+		Note:    "Cancelled: " + in.Reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	stamp := map[string]interface{}{
+		"cancelled_at":  now,
+		"cancelled_by":  in.ActorID,
+		"cancel_reason": in.Reason,
+	}
+	if err := tx.Model(&models.Transfer{}).Where("id IN ?", tripTransferIDs(tx, transfer)).Updates(stamp).Error; err != nil {
+		return nil, err
+	}
+	if transfer.ConsignmentID != nil {
+		if err := tx.Model(&models.Consignment{}).Where("id = ?", *transfer.ConsignmentID).Updates(stamp).Error; err != nil {
+			return nil, err
+		}
+	}
+	transfer.CancelledAt, transfer.CancelledBy, transfer.CancelReason = &now, in.ActorID, in.Reason
+
+	if transfer.SolutionCardID == nil && !transfer.StockReserved {
+		transfer.SolutionCardID = legacyCardID(tx, transfer)
+	}
+	if transfer.SolutionCardID != nil {
+		if err := cancelCard(tx, *transfer.SolutionCardID, transfer.ID, in); err != nil {
+			return nil, err
+		}
+	}
+	return transfer, nil
+}
+
+// cancelCard marks an approved card whose transfer was called off.
+func cancelCard(tx *gorm.DB, cardID, transferID string, in CancelInput) error {
+	var card models.SolutionCard
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&card, "id = ?", cardID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil // Card rows may have been cleaned up; the transfer still cancels
+		}
+		return err
+	}
+	if card.Status == models.CardCancelled {
+		return nil
+	}
+	from := card.Status
+	if err := tx.Model(&card).Update("status", models.CardCancelled).Error; err != nil {
+		return err
+	}
+	return RecordCardEvent(tx, card.ID, CardChange{
+		Action:     models.CardActionCancelled,
+		FromStatus: from,
+		ToStatus:   models.CardCancelled,
+		ActorID:    in.ActorID,
+		Note:       fmt.Sprintf("Transfer %s cancelled: %s", transferID, in.Reason),
+	})
+}
+
+// legacyCardID finds the card behind a transfer approved before transfers
+// recorded their card. That approval read these four payload keys, so an
+// approved card carrying the same values is the source; if more than one
+// matches there is no telling which, and none is returned.
+func legacyCardID(tx *gorm.DB, t *models.Transfer) *string {
+	var ids []string
+	tx.Model(&models.SolutionCard{}).
+		Where("status = ? AND created_at <= ?", models.CardApproved, t.CreatedAt).
+		Where("payload->>'source_facility_id' = ? AND payload->>'destination_facility_id' = ? AND payload->>'item_id' = ?", t.FromFacilityID, t.ToFacilityID, t.ItemID).
+		Where("(payload->>'quantity')::numeric = ?", t.Quantity).
+		Limit(2).Pluck("id", &ids)
+	if len(ids) != 1 {
+		return nil
+	}
+	return &ids[0]
+}
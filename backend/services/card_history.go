@@ -0,0 +1,36 @@
+package services
+
+import (
+	"backend/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// CardChange describes one change to a card for its history.
+type CardChange struct {
+	Action       string
+	FromStatus   string
+	ToStatus     string
+	FromPriority *int
+	ToPriority   *int
+	ActorID      string
+	Note         string
+}
+
+// RecordCardEvent appends an entry to a card's history.
+func RecordCardEvent(tx *gorm.DB, cardID string, ch CardChange) error {
+	return tx.Create(&models.SolutionCardEvent{
+		ID:           uuid.New().String(),
+		CardID:       cardID,
+		Action:       ch.Action,
+		FromStatus:   ch.FromStatus,
+		ToStatus:     ch.ToStatus,
+		FromPriority: ch.FromPriority,
+		ToPriority:   ch.ToPriority,
+		ActorID:      ch.ActorID,
+		Note:         ch.Note,
+		CreatedAt:    time.Now(),
+	}).Error
+}
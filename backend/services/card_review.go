@@ -0,0 +1,149 @@
+package services
+
+import (
+	"backend/models"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CardReviewSummary counts what one review pass did.
+type CardReviewSummary struct {
+	Reviewed  int      `json:"reviewed"`
+	Expired   []string `json:"expired"`
+	Escalated []string `json:"escalated"`
+	Failed    int      `json:"failed"`
+}
+
+// ReviewPendingCards re-evaluates every pending card. Each card is handled
+// in its own transaction; db must not be a transaction itself.
+//
+// A card expires when its recipient is no longer projected to fall below
+// safety stock, or when its donor can no longer spare the card's quantity
+// above safety stock. Otherwise cards older than the district SLA
+// ("card_sla_hours") get their PriorityScore raised by
+// "card_escalation_step" once per SLA period, up to "card_priority_max".
+func ReviewPendingCards(db *gorm.DB, now time.Time) (*CardReviewSummary, error) {
+	var ids []string
+	if err := db.Model(&models.SolutionCard{}).Where("status = ?", models.CardPending).
+		Order("created_at ASC").Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+
+	summary := &CardReviewSummary{}
+	for _, id := range ids {
+		var action string
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			action, err = reviewCard(tx, id, now)
+			return err
+		})
+		if err != nil {
+			summary.Failed++
+			continue
+		}
+		summary.Reviewed++
+		switch action {
+		case models.CardActionExpired:
+			summary.Expired = append(summary.Expired, id)
+		case models.CardActionEscalated:
+			summary.Escalated = append(summary.Escalated, id)
+		}
+	}
+	return summary, nil
+}
+
+// reviewCard returns the action taken, or "" when the card was left alone.
+func reviewCard(tx *gorm.DB, cardID string, now time.Time) (string, error) {
+	card, err := lockPendingCard(tx, cardID)
+	if errors.Is(err, ErrAlreadyProcessed) {
+		return "", nil // Decided since the list was read
+	}
+	if err != nil {
+		return "", err
+	}
+	spec, err := ResolveTransferSpec(tx, card)
+	if err != nil {
+		return "", nil // Cannot judge an incomplete card; a reviewer has to
+	}
+	district := facilityDistrict(tx, spec.ToFacilityID)
+
+	// 1. Recipient no longer at risk on any line
+	days := DistrictSettingInt(tx, district, "card_risk_forecast_days", 3)
+	reason := ""
+	for _, l := range spec.Lines {
+		var recipient models.Inventory
+		if err := tx.Where("facility_id = ? AND item_id = ?", spec.ToFacilityID, l.ItemID).First(&recipient).Error; err != nil {
+			reason = ""
+			break // No stock at all is a risk
+		}
+		projected := float64(recipient.Quantity) - recipient.ConsumptionRate*float64(days)
+		if projected < float64(recipient.SafetyStockLevel) {
+			reason = ""
+			break
+		}
+		reason = fmt.Sprintf("Recipient no longer at risk: %.0f units projected in %d days, safety stock %d", projected, days, recipient.SafetyStockLevel)
+	}
+	if reason != "" {
+		return models.CardActionExpired, expireCard(tx, card, reason)
+	}
+
+	// 2. Donor can no longer give one of the lines
+	for _, l := range spec.Lines {
+		var donor models.Inventory
+		if err := tx.Where("facility_id = ? AND item_id = ?", spec.FromFacilityID, l.ItemID).First(&donor).Error; err != nil {
+			return models.CardActionExpired, expireCard(tx, card, "Donor no longer stocks item "+l.ItemID)
+		}
+		if spare := donor.Available() - donor.SafetyStockLevel; spare < l.Quantity {
+			return models.CardActionExpired, expireCard(tx, card,
+				fmt.Sprintf("Donor can spare only %d of %d units above safety level (%d available, safety stock %d)",
+					max(spare, 0), l.Quantity, donor.Available(), donor.SafetyStockLevel))
+		}
+	}
+
+	// 3. SLA escalation, one step per SLA period elapsed
+	slaHours := DistrictSettingFloat(tx, district, "card_sla_hours", 4)
+	step := DistrictSettingInt(tx, district, "card_escalation_step", 1)
+	maxPriority := DistrictSettingInt(tx, district, "card_priority_max", 10)
+	if slaHours <= 0 || step <= 0 || card.PriorityScore >= maxPriority {
+		return "", nil
+	}
+
+	var escalations int64
+	tx.Model(&models.SolutionCardEvent{}).Where("card_id = ? AND action = ?", card.ID, models.CardActionEscalated).Count(&escalations)
+	age := now.Sub(card.CreatedAt)
+	if age < time.Duration(float64(escalations+1)*slaHours*float64(time.Hour)) {
+		return "", nil
+	}
+
+	from := card.PriorityScore
+	to := from + step
+	if to > maxPriority {
+		to = maxPriority
+	}
+	if err := tx.Model(card).Update("priority_score", to).Error; err != nil {
+		return "", err
+	}
+	return models.CardActionEscalated, RecordCardEvent(tx, card.ID, CardChange{
+		Action:       models.CardActionEscalated,
+		FromStatus:   models.CardPending,
+		ToStatus:     models.CardPending,
+		FromPriority: &from,
+		ToPriority:   &to,
+		Note:         fmt.Sprintf("Pending %.1fh, SLA %.1fh (escalation %d)", age.Hours(), slaHours, escalations+1),
+	})
+}
+
+func expireCard(tx *gorm.DB, card *models.SolutionCard, reason string) error {
+	if err := tx.Model(card).Update("status", models.CardExpired).Error; err != nil {
+		return err
+	}
+	return RecordCardEvent(tx, card.ID, CardChange{
+		Action:     models.CardActionExpired,
+		FromStatus: models.CardPending,
+		ToStatus:   models.CardExpired,
+		Note:       reason,
+	})
+}
@@ -0,0 +1,271 @@
+package services
+
+import (
+	"backend/models"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// TransferSpec is what a SolutionCard asks to move, resolved from the
+// payload with the card columns as fallback. ItemID and Quantity are the
+// first of Lines; a card with more than one line becomes a consignment.
+type TransferSpec struct {
+	FromFacilityID string
+	ToFacilityID   string
+	ItemID         string
+	Quantity       int
+	VehicleType    string
+	Lines          []TransferLine
+	Via            []string // Hub facility IDs, in route order
+}
+
+// TransferLine is one item of a TransferSpec.
+type TransferLine struct {
+	ItemID   string
+	Quantity int
+}
+
+// MultiLine reports whether the spec moves more than one item.
+func (s *TransferSpec) MultiLine() bool {
+	return len(s.Lines) > 1
+}
+
+// NormalizeCardPayload upgrades a card's payload to the current schema and
+// fills in what older writers left out: the item ID from its name, facility
+// IDs given as names, and display names. It does not validate; lookups that
+// fail leave the field as it was.
+func NormalizeCardPayload(tx *gorm.DB, card *models.SolutionCard) *models.CardPayload {
+	p := models.ParseCardPayload(card)
+	fillPayload(tx, &p)
+	return &p
+}
+
+// ResolveTransferSpec reads a card's payload into a TransferSpec. Cards
+// written by the ML agent name the item instead of carrying its ID, so the
+// name is looked up against the items table.
+func ResolveTransferSpec(tx *gorm.DB, card *models.SolutionCard) (*TransferSpec, error) {
+	p := NormalizeCardPayload(tx, card)
+	if err := validatePayloadFields(p); err != nil {
+		return nil, err
+	}
+	spec := &TransferSpec{
+		FromFacilityID: p.SourceFacilityID,
+		ToFacilityID:   p.DestinationFacilityID,
+		VehicleType:    p.TransportMode,
+		Via:            p.Via,
+	}
+	for _, l := range p.AllLines() {
+		spec.Lines = append(spec.Lines, TransferLine{ItemID: l.ItemID, Quantity: l.Quantity})
+	}
+	spec.ItemID, spec.Quantity = spec.Lines[0].ItemID, spec.Lines[0].Quantity
+	if spec.VehicleType == "" {
+		spec.VehicleType = models.VehicleVan
+	}
+	return spec, nil
+}
+
+// SetCardPayload validates a payload against the database and stores it on
+// the card, keeping the facility columns in step. Every card written by the
+// Go backend goes through here.
+func SetCardPayload(tx *gorm.DB, card *models.SolutionCard, p models.CardPayload) error {
+	fillPayload(tx, &p)
+	if err := validatePayloadFields(&p); err != nil {
+		return err
+	}
+
+	var count int64
+	tx.Model(&models.Facility{}).Where("id IN ?", []string{p.SourceFacilityID, p.DestinationFacilityID}).Count(&count)
+	if count != 2 {
+		return fmt.Errorf("%w: unknown source or destination facility", ErrInvalidInput)
+	}
+	for _, l := range p.AllLines() {
+		var item models.Item
+		if err := tx.First(&item, "id = ?", l.ItemID).Error; err != nil {
+			return fmt.Errorf("%w: unknown item %s", ErrInvalidInput, l.ItemID)
+		}
+	}
+	for _, hub := range p.Via {
+		var f models.Facility
+		if err := tx.Select("id").First(&f, "id = ?", hub).Error; err != nil {
+			return fmt.Errorf("%w: unknown hub %s", ErrInvalidInput, hub)
+		}
+	}
+
+	card.Payload = p.Map()
+	card.FromFacilityID = &p.SourceFacilityID
+	card.ToFacilityID = &p.DestinationFacilityID
+	return nil
+}
+
+func validatePayloadFields(p *models.CardPayload) error {
+	switch {
+	case p.SourceFacilityID == "" || p.DestinationFacilityID == "":
+		return fmt.Errorf("%w: card has no source or destination facility", ErrInvalidInput)
+	case p.SourceFacilityID == p.DestinationFacilityID:
+		return fmt.Errorf("%w: source and destination are the same facility", ErrInvalidInput)
+	case len(p.Lines) > 1 && len(p.Via) > 0:
+		return fmt.Errorf("%w: multi-item consignments cannot be routed via hubs", ErrInvalidInput)
+	}
+	seen := map[string]bool{}
+	for _, l := range p.AllLines() {
+		switch {
+		case l.ItemID == "":
+			return fmt.Errorf("%w: card does not identify an item", ErrInvalidInput)
+		case l.Quantity <= 0:
+			return fmt.Errorf("%w: card has no quantity", ErrInvalidInput)
+		case seen[l.ItemID]:
+			return fmt.Errorf("%w: item %s appears on two lines", ErrInvalidInput, l.ItemID)
+		}
+		seen[l.ItemID] = true
+	}
+	return nil
+}
+
+// fillPayload resolves item and facility references both ways, ID to name
+// and name to ID.
+// A single line is folded back into the top-level item fields.
+func fillPayload(tx *gorm.DB, p *models.CardPayload) {
+	loadRefs(tx, p).fill(p)
+}
+
+// refIndex holds the items and facilities a set of payloads refers to, so
+// a list of cards is resolved with two queries rather than a few per card.
+type refIndex struct {
+	items         map[string]models.Item
+	itemNames     map[string]models.Item // Lower-cased name and generic name
+	facilities    map[string]models.Facility
+	facilityNames map[string]models.Facility // Lower-cased name
+}
+
+func loadRefs(tx *gorm.DB, payloads ...*models.CardPayload) *refIndex {
+	idx := &refIndex{
+		items:         map[string]models.Item{},
+		itemNames:     map[string]models.Item{},
+		facilities:    map[string]models.Facility{},
+		facilityNames: map[string]models.Facility{},
+	}
+	var itemIDs, itemNames, facilityRefs []string
+	addItem := func(id, name string) {
+		if id == "" && name != "" {
+			itemNames = append(itemNames, strings.ToLower(name))
+		} else if id != "" && name == "" {
+			itemIDs = append(itemIDs, id)
+		}
+	}
+	addFacility := func(ref, name string) {
+		if ref != "" && name == "" {
+			facilityRefs = append(facilityRefs, ref)
+		}
+	}
+	for _, p := range payloads {
+		addItem(p.ItemID, p.ItemName)
+		for _, l := range p.Lines {
+			addItem(l.ItemID, l.ItemName)
+		}
+		addFacility(p.SourceFacilityID, p.SourceFacilityName)
+		addFacility(p.DestinationFacilityID, p.DestinationFacilityName)
+		for _, hub := range p.Via {
+			addFacility(hub, "")
+		}
+	}
+
+	if len(itemIDs)+len(itemNames) > 0 {
+		var items []models.Item
+		tx.Select("id, name, generic_name").
+			Where("id IN ? OR LOWER(name) IN ? OR LOWER(generic_name) IN ?", itemIDs, itemNames, itemNames).
+			Order("id").Find(&items)
+		for _, item := range items {
+			idx.items[item.ID] = item
+			for _, name := range []string{item.Name, item.GenericName} {
+				if _, seen := idx.itemNames[strings.ToLower(name)]; name != "" && !seen {
+					idx.itemNames[strings.ToLower(name)] = item
+				}
+			}
+		}
+	}
+	if len(facilityRefs) > 0 {
+		lowered := make([]string, len(facilityRefs))
+		for i, ref := range facilityRefs {
+			lowered[i] = strings.ToLower(ref)
+		}
+		var facilities []models.Facility
+		tx.Select("id, name").Where("id IN ? OR LOWER(name) IN ?", facilityRefs, lowered).Order("id").Find(&facilities)
+		for _, f := range facilities {
+			idx.facilities[f.ID] = f
+			if _, seen := idx.facilityNames[strings.ToLower(f.Name)]; !seen {
+				idx.facilityNames[strings.ToLower(f.Name)] = f
+			}
+		}
+	}
+	return idx
+}
+
+func (idx *refIndex) fill(p *models.CardPayload) {
+	if len(p.Lines) == 1 {
+		p.ItemID, p.ItemName, p.Quantity = p.Lines[0].ItemID, p.Lines[0].ItemName, p.Lines[0].Quantity
+		p.Lines = nil
+	}
+	p.ItemID, p.ItemName = idx.item(p.ItemID, p.ItemName)
+	for i := range p.Lines {
+		p.Lines[i].ItemID, p.Lines[i].ItemName = idx.item(p.Lines[i].ItemID, p.Lines[i].ItemName)
+	}
+	p.SourceFacilityID, p.SourceFacilityName = idx.facility(p.SourceFacilityID, p.SourceFacilityName)
+	p.DestinationFacilityID, p.DestinationFacilityName = idx.facility(p.DestinationFacilityID, p.DestinationFacilityName)
+	for i := range p.Via {
+		p.Via[i], _ = idx.facility(p.Via[i], "")
+	}
+}
+
+func (idx *refIndex) item(id, name string) (string, string) {
+	if id == "" && name != "" {
+		if item, ok := idx.itemNames[strings.ToLower(name)]; ok {
+			return item.ID, name
+		}
+	} else if id != "" && name == "" {
+		if item, ok := idx.items[id]; ok {
+			return id, item.Name
+		}
+	}
+	return id, name
+}
+
+// facility accepts either an ID or, as the ML agent sometimes writes, a
+// facility name in the ID field.
+func (idx *refIndex) facility(ref, name string) (string, string) {
+	if ref == "" || name != "" {
+		return ref, name
+	}
+	if f, ok := idx.facilities[ref]; ok {
+		return f.ID, f.Name
+	}
+	if f, ok := idx.facilityNames[strings.ToLower(ref)]; ok {
+		return f.ID, f.Name
+	}
+	return ref, name
+}
+
+// NormalizeCardPayloads normalizes the payloads of a list of cards, as
+// NormalizeCardPayload does for one, loading the references once.
+func NormalizeCardPayloads(tx *gorm.DB, cards []models.SolutionCard) []*models.CardPayload {
+	payloads := make([]*models.CardPayload, len(cards))
+	for i := range cards {
+		p := models.ParseCardPayload(&cards[i])
+		payloads[i] = &p
+	}
+	idx := loadRefs(tx, payloads...)
+	for _, p := range payloads {
+		idx.fill(p)
+	}
+	return payloads
+}
+
+// CanonicalPayloads rewrites each card's payload, in memory only, to the
+// current schema so API clients read one shape.
+func CanonicalPayloads(tx *gorm.DB, cards []models.SolutionCard) []models.SolutionCard {
+	for i, p := range NormalizeCardPayloads(tx, cards) {
+		cards[i].Payload = p.Map()
+	}
+	return cards
+}
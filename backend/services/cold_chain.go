@@ -0,0 +1,332 @@
+package services
+
+import (
+	"backend/models"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// coldChainItems returns which of the items need a refrigerated vehicle.
+func coldChainItems(tx *gorm.DB, itemIDs ...string) map[string]bool {
+	cold := map[string]bool{}
+	if len(itemIDs) == 0 {
+		return cold
+	}
+	var items []models.Item
+	tx.Select("id, storage_condition").Where("id IN ?", itemIDs).Find(&items)
+	for _, item := range items {
+		if item.NeedsColdChain() {
+			cold[item.ID] = true
+		}
+	}
+	return cold
+}
+
+func itemIDs(spec *TransferSpec) []string {
+	ids := make([]string, 0, len(spec.Lines))
+	for _, l := range spec.Lines {
+		ids = append(ids, l.ItemID)
+	}
+	return ids
+}
+
+// ReadingInput is one row of a temperature logger export.
+type ReadingInput struct {
+	LoggerID   string
+	RecordedAt time.Time
+	TempC      float64
+}
+
+// TemperatureUpload is who is uploading a logger export.
+type TemperatureUpload struct {
+	ActorID    string
+	Role       string
+	FacilityID string
+}
+
+// Excursion is a run of consecutive readings outside an item's range.
+type Excursion struct {
+	TransferID string    `json:"transfer_id"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Minutes    float64   `json:"minutes"`
+	LowestC    float64   `json:"lowest_c"`
+	HighestC   float64   `json:"highest_c"`
+	Readings   int       `json:"readings"`
+}
+
+// TemperatureReport is the outcome of an upload for every line of the trip.
+type TemperatureReport struct {
+	TransferID  string      `json:"transfer_id"`
+	Received    int         `json:"received"` // Rows in this upload
+	Stored      int         `json:"stored"`   // New rows; repeats of earlier uploads are skipped
+	Excursions  []Excursion `json:"excursions"`
+	Flagged     []string    `json:"flagged"`     // Transfers newly marked with an excursion
+	Quarantined []string    `json:"quarantined"` // Of those, already delivered or returned and quarantined now
+}
+
+// RecordTemperatureLog stores a logger export for a transfer and checks
+// the readings taken in transit (from pickup to delivery, or until now)
+// against each cold-chain item on the trip; exports usually include room
+// temperature before packing and after unpacking, which do not count. An
+// excursion longer than the district's cold_chain_excursion_minutes
+// (default 0: any reading out of range) flags the transfer, writes a
+// compliance entry and quarantines the batches wherever they are held:
+// at the recipient now if delivered or on delivery otherwise, at the donor
+// if the transfer was cancelled and the stock returned. The driver, the DHO
+// or staff of either facility may upload.
+func RecordTemperatureLog(tx *gorm.DB, transferID string, readings []ReadingInput, in TemperatureUpload) (*TemperatureReport, error) {
+	if len(readings) == 0 {
+		return nil, fmt.Errorf("%w: the log has no readings", ErrInvalidInput)
+	}
+
+	lines, err := lockTrip(tx, transferID)
+	if err != nil {
+		return nil, err
+	}
+	head := lines[0]
+	for _, l := range lines {
+		if l.ID == transferID {
+			head = l
+		}
+	}
+	isDriver := head.DriverID != nil && *head.DriverID == in.ActorID
+	atFacility := in.FacilityID != "" && (in.FacilityID == head.FromFacilityID || in.FacilityID == head.ToFacilityID)
+	if in.Role != RoleDHO && !isDriver && !atFacility {
+		return nil, fmt.Errorf("%w: only the driver, the DHO or the donor or recipient facility can upload a temperature log", ErrForbidden)
+	}
+	if head.PickedUpAt == nil {
+		return nil, fmt.Errorf("%w: transfer has not been picked up", ErrInvalidTransition)
+	}
+
+	ranges := map[string][2]float64{}
+	for _, l := range lines {
+		var item models.Item
+		if err := tx.First(&item, "id = ?", l.ItemID).Error; err != nil {
+			continue
+		}
+		if lo, hi, ok := item.TempRange(); ok {
+			ranges[l.ID] = [2]float64{lo, hi}
+		}
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("%w: no item on this transfer has a temperature range", ErrInvalidInput)
+	}
+
+	// 1. Store the readings
+	report := &TemperatureReport{TransferID: head.ID, Received: len(readings), Excursions: []Excursion{}, Flagged: []string{}, Quarantined: []string{}}
+	now := time.Now()
+	for _, r := range readings {
+		row := models.TemperatureReading{
+			ID:         uuid.New().String(),
+			TransferID: head.ID,
+			LoggerID:   strings.TrimSpace(r.LoggerID),
+			RecordedAt: r.RecordedAt,
+			TempC:      r.TempC,
+			UploadedBy: in.ActorID,
+			CreatedAt:  now,
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&row)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		report.Stored += int(result.RowsAffected)
+	}
+
+	// 2. Check the whole trip's log per item
+	ids := make([]string, 0, len(lines))
+	for _, l := range lines {
+		ids = append(ids, l.ID)
+	}
+	var trail []models.TemperatureReading
+	if err := tx.Where("transfer_id IN ?", ids).Order("recorded_at").Find(&trail).Error; err != nil {
+		return nil, err
+	}
+	tolerance := float64(DistrictSettingInt(tx, facilityDistrict(tx, head.FromFacilityID), "cold_chain_excursion_minutes", 0))
+
+	for i := range lines {
+		line := &lines[i]
+		limits, ok := ranges[line.ID]
+		if !ok {
+			continue
+		}
+		var breaches []Excursion
+		for _, e := range findExcursions(inTransit(trail, line), limits[0], limits[1]) {
+			if e.Minutes >= tolerance {
+				e.TransferID = line.ID
+				breaches = append(breaches, e)
+			}
+		}
+		report.Excursions = append(report.Excursions, breaches...)
+		if len(breaches) == 0 || line.TempExcursion {
+			continue
+		}
+
+		// 3. Newly out of range: flag, quarantine and record it
+		if err := tx.Model(line).Update("temp_excursion", true).Error; err != nil {
+			return nil, err
+		}
+		line.TempExcursion = true
+		report.Flagged = append(report.Flagged, line.ID)
+
+		action, heldAt := "Batches will be quarantined on receipt", ""
+		switch line.Status {
+		case models.TransferDelivered:
+			action, heldAt = "Received batches quarantined", line.ToFacilityID
+		case models.TransferCancelled:
+			action, heldAt = "Batches returned to the donor quarantined", line.FromFacilityID
+		}
+		logAt := line.ToFacilityID
+		if heldAt != "" {
+			if err := quarantineBatches(tx, heldAt, line.ItemID, line.Batches); err != nil {
+				return nil, err
+			}
+			report.Quarantined = append(report.Quarantined, line.ID)
+			logAt = heldAt
+		}
+		if err := logExcursion(tx, line, logAt, limits, breaches, in.ActorID, action); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// lockTrip locks a transfer, or every line of its consignment in the usual
+// consignment-first order.
+func lockTrip(tx *gorm.DB, transferID string) ([]models.Transfer, error) {
+	var head models.Transfer
+	if err := tx.Select("id, consignment_id").First(&head, "id = ?", transferID).Error; err != nil {
+		return nil, fmt.Errorf("%w: transfer %s", ErrNotFound, transferID)
+	}
+	if head.ConsignmentID != nil {
+		c, err := lockConsignment(tx, *head.ConsignmentID)
+		if err != nil {
+			return nil, err
+		}
+		return c.Lines, nil
+	}
+	var t models.Transfer
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&t, "id = ?", transferID).Error; err != nil {
+		return nil, err
+	}
+	return []models.Transfer{t}, nil
+}
+
+// inTransit keeps the readings taken while the transfer was on the road.
+func inTransit(readings []models.TemperatureReading, t *models.Transfer) []models.TemperatureReading {
+	end := time.Now()
+	switch {
+	case t.ActualDeliveryTime != nil:
+		end = *t.ActualDeliveryTime
+	case t.CancelledAt != nil:
+		end = *t.CancelledAt
+	}
+	var out []models.TemperatureReading
+	for _, r := range readings {
+		if t.PickedUpAt != nil && !r.RecordedAt.Before(*t.PickedUpAt) && !r.RecordedAt.After(end) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// findExcursions groups consecutive out-of-range readings. An excursion
+// lasts from its first to its last reading outside the range.
+func findExcursions(readings []models.TemperatureReading, lo, hi float64) []Excursion {
+	sorted := append([]models.TemperatureReading(nil), readings...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].RecordedAt.Before(sorted[j].RecordedAt) })
+
+	var out []Excursion
+	var cur *Excursion
+	for _, r := range sorted {
+		if r.TempC >= lo && r.TempC <= hi {
+			cur = nil
+			continue
+		}
+		if cur == nil {
+			out = append(out, Excursion{Start: r.RecordedAt, LowestC: r.TempC, HighestC: r.TempC})
+			cur = &out[len(out)-1]
+		}
+		cur.End = r.RecordedAt
+		cur.Minutes = cur.End.Sub(cur.Start).Minutes()
+		cur.Readings++
+		if r.TempC < cur.LowestC {
+			cur.LowestC = r.TempC
+		}
+		if r.TempC > cur.HighestC {
+			cur.HighestC = r.TempC
+		}
+	}
+	return out
+}
+
+// quarantineBatches holds a transfer's batches back at the facility that
+// received them; they no longer count as available or get picked. Only the
+// transfer's own units move to a quarantined entry, so the facility's other
+// stock of the same batch stays usable.
+func quarantineBatches(tx *gorm.DB, facilityID, itemID string, batches models.BatchList) error {
+	if len(batches) == 0 {
+		return nil
+	}
+	inv, err := lockInventory(tx, facilityID, itemID)
+	if err != nil {
+		return err
+	}
+	return tx.Model(inv).Updates(map[string]interface{}{
+		"batch_metadata": MarkQuarantined(inv.BatchMetadata, batches),
+		"updated_at":     time.Now(),
+	}).Error
+}
+
+func logExcursion(tx *gorm.DB, t *models.Transfer, facilityID string, limits [2]float64, breaches []Excursion, actorID, action string) error {
+	var item models.Item
+	tx.Select("name").First(&item, "id = ?", t.ItemID)
+	name := item.Name
+	if name == "" {
+		name = t.ItemID
+	}
+
+	worst := breaches[0]
+	for _, e := range breaches[1:] {
+		if e.Minutes > worst.Minutes {
+			worst = e
+		}
+	}
+	return tx.Create(&models.ComplianceLog{
+		ID:         uuid.New().String(),
+		CreatedAt:  time.Now(),
+		FacilityID: facilityID,
+		UserID:     actorID,
+		ViolationDetails: fmt.Sprintf("Cold-chain excursion: %s outside %s (%d excursions, longest %.0f min, %.1f to %.1f °C) on transfer %s",
+			name, rangeText(limits[0], limits[1]), len(breaches), worst.Minutes, worst.LowestC, worst.HighestC, t.ID),
+		ActionTaken: action,
+	}).Error
+}
+
+func rangeText(lo, hi float64) string {
+	switch {
+	case math.IsInf(lo, -1):
+		return fmt.Sprintf("at most %.1f °C", hi)
+	case math.IsInf(hi, 1):
+		return fmt.Sprintf("at least %.1f °C", lo)
+	}
+	return fmt.Sprintf("%.1f to %.1f °C", lo, hi)
+}
+
+// TemperatureLog returns the readings uploaded for a transfer's trip, oldest first.
+func TemperatureLog(tx *gorm.DB, transferID string) ([]models.TemperatureReading, error) {
+	var t models.Transfer
+	if err := tx.Select("id, consignment_id").First(&t, "id = ?", transferID).Error; err != nil {
+		return nil, fmt.Errorf("%w: transfer %s", ErrNotFound, transferID)
+	}
+	readings := []models.TemperatureReading{}
+	err := tx.Where("transfer_id IN ?", tripTransferIDs(tx, &t)).Order("recorded_at").Find(&readings).Error
+	return readings, err
+}
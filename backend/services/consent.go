@@ -0,0 +1,132 @@
+package services
+
+import (
+	"backend/models"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Approval steps. The district setting "approval_steps" lists the steps
+// a card goes through, e.g. "donor_consent,dho"; the DHO sign-off is
+// always last and always required.
+const (
+	StepDonorConsent = "donor_consent"
+	StepDHO          = "dho"
+)
+
+// ApprovalSteps returns the district's configured steps, ending with StepDHO.
+func ApprovalSteps(tx *gorm.DB, district string) []string {
+	var steps []string
+	for _, s := range strings.Split(DistrictSetting(tx, district, "approval_steps", StepDHO), ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == StepDonorConsent {
+			steps = append(steps, s)
+		}
+	}
+	return append(steps, StepDHO)
+}
+
+// PendingStep is the step a pending card is waiting for.
+func PendingStep(tx *gorm.DB, card *models.SolutionCard) string {
+	return pendingStep(card, ApprovalSteps(tx, facilityDistrict(tx, cardDonorID(card))))
+}
+
+func pendingStep(card *models.SolutionCard, steps []string) string {
+	for _, s := range steps {
+		if s == StepDonorConsent && card.DonorConsent != models.ConsentGiven {
+			return s
+		}
+	}
+	return StepDHO
+}
+
+// FilterByStep keeps the cards waiting for the given step, reading each
+// donor district's steps once.
+func FilterByStep(tx *gorm.DB, cards []models.SolutionCard, step string) []models.SolutionCard {
+	stepsOf := map[string][]string{}
+	districtOf := map[string]string{}
+	kept := []models.SolutionCard{}
+	for i := range cards {
+		donor := cardDonorID(&cards[i])
+		district, ok := districtOf[donor]
+		if !ok {
+			district = facilityDistrict(tx, donor)
+			districtOf[donor] = district
+		}
+		steps, ok := stepsOf[district]
+		if !ok {
+			steps = ApprovalSteps(tx, district)
+			stepsOf[district] = steps
+		}
+		if pendingStep(&cards[i], steps) == step {
+			kept = append(kept, cards[i])
+		}
+	}
+	return kept
+}
+
+// ConsentInput is the donor PHC's answer to a card.
+type ConsentInput struct {
+	ActorID    string
+	FacilityID string
+	Consent    bool
+	Reason     string // Required when declining
+}
+
+// RespondAsDonor records the donor facility's consent or refusal. A
+// refusal closes the card as rejected; consent passes it to the DHO.
+func RespondAsDonor(tx *gorm.DB, cardID string, in ConsentInput) (*ApprovalResult, error) {
+	card, err := lockPendingCard(tx, cardID)
+	if err != nil {
+		return nil, err
+	}
+	donor := cardDonorID(card)
+	if in.FacilityID == "" || in.FacilityID != donor {
+		return nil, fmt.Errorf("%w: only staff of the donor facility can answer for it", ErrForbidden)
+	}
+	if PendingStep(tx, card) != StepDonorConsent {
+		return nil, fmt.Errorf("%w: card is not awaiting donor consent", ErrInvalidTransition)
+	}
+	in.Reason = strings.TrimSpace(in.Reason)
+	if !in.Consent && in.Reason == "" {
+		return nil, fmt.Errorf("%w: a reason is required to decline", ErrInvalidInput)
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"donor_consent_by": in.ActorID,
+		"donor_consent_at": now,
+	}
+	change := CardChange{FromStatus: models.CardPending, ActorID: in.ActorID}
+	result := &ApprovalResult{Status: "success", CardID: card.ID}
+	if in.Consent {
+		updates["donor_consent"] = models.ConsentGiven
+		change.Action = models.CardActionConsented
+		change.ToStatus = models.CardPending
+		result.Message = "Donor consent recorded; awaiting DHO approval"
+	} else {
+		updates["donor_consent"] = models.ConsentDeclined
+		updates["donor_decline_reason"] = in.Reason
+		updates["status"] = models.CardRejected
+		change.Action = models.CardActionDeclined
+		change.ToStatus = models.CardRejected
+		change.Note = in.Reason
+		result.Message = "Donor declined the transfer"
+	}
+
+	if err := tx.Model(card).Updates(updates).Error; err != nil {
+		return nil, fmt.Errorf("failed to update card: %w", err)
+	}
+	if err := RecordCardEvent(tx, card.ID, change); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// cardDonorID mirrors ResolveTransferSpec: payload first, then the column.
+func cardDonorID(card *models.SolutionCard) string {
+	return models.ParseCardPayload(card).SourceFacilityID
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"backend/models"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// lockConsignment loads a consignment and then its lines FOR UPDATE. Every
+// path that touches a consignment locks in this order.
+func lockConsignment(tx *gorm.DB, id string) (*models.Consignment, error) {
+	var c models.Consignment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&c, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: consignment %s", ErrNotFound, id)
+		}
+		return nil, err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("consignment_id = ?", id).Order("id").Find(&c.Lines).Error; err != nil {
+		return nil, err
+	}
+	if len(c.Lines) == 0 {
+		return nil, fmt.Errorf("%w: consignment %s has no lines", ErrNotFound, id)
+	}
+	return &c, nil
+}
+
+// TransitionConsignment moves every line to the same status in one step and
+// mirrors the result on the consignment. It must run inside a transaction.
+func TransitionConsignment(tx *gorm.DB, id, to string, in TransitionInput) (*models.Consignment, error) {
+	c, err := lockConsignment(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !CanTransition(c.Status, to) {
+		return nil, fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, c.Status, to)
+	}
+	for i := range c.Lines {
+		if err := transitionLine(tx, &c.Lines[i], to, in); err != nil {
+			return nil, err
+		}
+	}
+
+	first := c.Lines[0]
+	c.Status = to
+	c.UpdatedAt = first.UpdatedAt
+	c.PickedUpAt = first.PickedUpAt
+	c.EstimatedArrivalTime = first.EstimatedArrivalTime
+	c.ActualDeliveryTime = first.ActualDeliveryTime
+	if err := tx.Model(c).Updates(map[string]interface{}{
+		"status":                 c.Status,
+		"updated_at":             c.UpdatedAt,
+		"picked_up_at":           c.PickedUpAt,
+		"estimated_arrival_time": c.EstimatedArrivalTime,
+		"actual_delivery_time":   c.ActualDeliveryTime,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// createConsignment reserves stock and picks batches for every line of a
+// multi-item card, dispatches one driver for the whole load and creates the
+// consignment with one Transfer per line.
+func createConsignment(tx *gorm.DB, card *models.SolutionCard, spec *TransferSpec, actorID string) (*models.Consignment, *DriverCandidate, error) {
+	total := 0
+	for _, l := range spec.Lines {
+		total += l.Quantity
+	}
+
+	// 1. Reserve and pick per item
+	batches := make([]models.BatchList, len(spec.Lines))
+	for i, l := range spec.Lines {
+		if err := ReserveStock(tx, spec.FromFacilityID, l.ItemID, l.Quantity); err != nil {
+			return nil, nil, fmt.Errorf("item %s: %w", l.ItemID, err)
+		}
+		picked, err := pickTransferBatches(tx, spec.FromFacilityID, l.ItemID, l.Quantity)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to pick batches: %w", err)
+		}
+		batches[i] = picked
+	}
+
+	// 2. One driver and vehicle for the whole load, refrigerated if any item needs it
+	cold := coldChainItems(tx, itemIDs(spec)...)
+	driver, err := SelectDriver(tx, spec.FromFacilityID, total, len(cold) > 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to dispatch driver: %w", err)
+	}
+
+	now := time.Now()
+	c := models.Consignment{
+		ID:             uuid.New().String(),
+		SolutionCardID: &card.ID,
+		FromFacilityID: spec.FromFacilityID,
+		ToFacilityID:   spec.ToFacilityID,
+		Status:         models.TransferAwaitingDriver,
+		VehicleType:    spec.VehicleType,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if driver != nil {
+		c.Status = models.TransferApproved
+		c.DriverID = &driver.DriverID
+		c.VehicleID = &driver.VehicleID
+		c.VehicleType = driver.VehicleType
+		c.VehicleNumber = driver.Registration
+	}
+	c.EstimatedArrivalTime = EstimateArrivalAtApproval(tx, &models.Transfer{
+		FromFacilityID: c.FromFacilityID,
+		ToFacilityID:   c.ToFacilityID,
+		VehicleType:    c.VehicleType,
+	}, now)
+	if err := tx.Create(&c).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to create consignment: %w", err)
+	}
+
+	// 3. One transfer per line, sharing the consignment's trip
+	for i, l := range spec.Lines {
+		line := models.Transfer{
+			ID:                   uuid.New().String(),
+			SolutionCardID:       &card.ID,
+			ConsignmentID:        &c.ID,
+			FromFacilityID:       c.FromFacilityID,
+			ToFacilityID:         c.ToFacilityID,
+			ItemID:               l.ItemID,
+			Quantity:             l.Quantity,
+			ProposedQuantity:     l.Quantity,
+			Batches:              batches[i],
+			Status:               c.Status,
+			StockReserved:        true,
+			ColdChain:            cold[l.ItemID],
+			DriverID:             c.DriverID,
+			VehicleID:            c.VehicleID,
+			VehicleType:          c.VehicleType,
+			VehicleNumber:        c.VehicleNumber,
+			EstimatedArrivalTime: c.EstimatedArrivalTime,
+			CreatedAt:            now,
+			UpdatedAt:            now,
+		}
+		if err := tx.Create(&line).Error; err != nil {
+			return nil, nil, fmt.Errorf("failed to create transfer: %w", err)
+		}
+		if err := RecordTransferCreated(tx, &line, actorID); err != nil {
+			return nil, nil, fmt.Errorf("failed to create transfer: %w", err)
+		}
+		c.Lines = append(c.Lines, line)
+	}
+	return &c, driver, nil
+}
+
+// assignConsignmentDriver is AssignDriver for a whole consignment: the
+// driver's vehicle has to carry every line at once.
+func assignConsignmentDriver(tx *gorm.DB, id, driverID, actorID string) (*models.Consignment, error) {
+	c, err := lockConsignment(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if c.Status != models.TransferApproved && c.Status != models.TransferAwaitingDriver {
+		return nil, fmt.Errorf("%w: driver cannot be changed while consignment is %s", ErrInvalidTransition, c.Status)
+	}
+
+	var driver *DriverCandidate
+	if driverID != "" {
+		driver, err = driverWithVehicle(tx, c.FromFacilityID, driverID, c.DriverID, c.TotalQuantity(), c.ColdChain())
+	} else {
+		driver, err = SelectDriver(tx, c.FromFacilityID, c.TotalQuantity(), c.ColdChain())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	target := models.TransferAwaitingDriver
+	note := "No driver available"
+	updates := map[string]interface{}{"updated_at": time.Now(), "driver_id": nil, "vehicle_id": nil}
+	if driver != nil {
+		target = models.TransferApproved
+		note = "Driver assigned: " + driver.Email
+		updates["driver_id"] = driver.DriverID
+		updates["vehicle_id"] = driver.VehicleID
+		updates["vehicle_type"] = driver.VehicleType
+		updates["vehicle_number"] = driver.Registration
+		if eta := EstimateArrivalAtApproval(tx, &models.Transfer{
+			FromFacilityID: c.FromFacilityID,
+			ToFacilityID:   c.ToFacilityID,
+			VehicleType:    driver.VehicleType,
+		}, time.Now()); eta != nil {
+			updates["estimated_arrival_time"] = eta
+		}
+	}
+	if err := tx.Model(c).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&models.Transfer{}).Where("consignment_id = ?", c.ID).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+
+	in := TransitionInput{ActorID: actorID, Note: note}
+	if target != c.Status {
+		return TransitionConsignment(tx, c.ID, target, in)
+	}
+	for _, l := range c.Lines {
+		if err := recordTransferEvent(tx, l.ID, l.Status, target, in); err != nil {
+			return nil, err
+		}
+	}
+	return lockConsignment(tx, c.ID)
+}
+
+// LoadConsignment returns a consignment with its lines, facilities and items.
+func LoadConsignment(tx *gorm.DB, id string) (*models.Consignment, error) {
+	var c models.Consignment
+	if err := tx.Preload("FromFacility").Preload("ToFacility").
+		Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Preload("Lines.Item").
+		First(&c, "id = ?", id).Error; err != nil {
+		return nil, fmt.Errorf("%w: consignment %s", ErrNotFound, id)
+	}
+	return &c, nil
+}
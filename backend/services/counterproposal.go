@@ -0,0 +1,172 @@
+package services
+
+import (
+	"backend/models"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SurplusForecastDays is how many days of a donor's own consumption are
+// protected on top of its safety stock when working out true surplus.
+const SurplusForecastDays = 7
+
+// CounterproposalInput is a reviewer's alternative to an AI card.
+type CounterproposalInput struct {
+	ActorID  string
+	Role     string
+	District string
+	Donor    string // facility ID or exact facility name
+	Quantity int    // 0 keeps the original quantity
+}
+
+// CounterproposalResult links the rejected card to its successor.
+type CounterproposalResult struct {
+	Status          string `json:"status"`
+	Message         string `json:"message"`
+	RejectedCardID  string `json:"rejected_card_id"`
+	SuccessorCardID string `json:"successor_card_id"`
+	DonorFacilityID string `json:"donor_facility_id"`
+	Quantity        int    `json:"quantity"`
+}
+
+// RejectWithCounterproposal rejects a pending card and creates a pending
+// successor that draws on the reviewer's chosen donor. The successor is
+// only created if that donor has enough true surplus. Like RejectCard it
+// is for the DHO only, at any step.
+func RejectWithCounterproposal(tx *gorm.DB, cardID string, in CounterproposalInput) (*CounterproposalResult, error) {
+	card, err := lockPendingCard(tx, cardID)
+	if err != nil {
+		return nil, err
+	}
+	if in.Role != RoleDHO {
+		return nil, fmt.Errorf("%w: only the DHO can reject a transfer", ErrForbidden)
+	}
+	if err := checkCardDistrict(tx, card, in.District); err != nil {
+		return nil, err
+	}
+	spec, err := ResolveTransferSpec(tx, card)
+	if err != nil {
+		return nil, err
+	}
+	if spec.MultiLine() {
+		return nil, fmt.Errorf("%w: counter-proposals are for single-item cards; reject and raise a new card instead", ErrInvalidInput)
+	}
+
+	// 1. Resolve the alternate donor
+	donor, err := findDonorFacility(tx, in.Donor)
+	if err != nil {
+		return nil, err
+	}
+	if donor.ID == spec.ToFacilityID {
+		return nil, fmt.Errorf("%w: donor and recipient are the same facility", ErrInvalidInput)
+	}
+
+	qty := in.Quantity
+	if qty == 0 {
+		qty = spec.Quantity
+	}
+	if qty < 0 {
+		return nil, fmt.Errorf("%w: quantity must be positive", ErrInvalidInput)
+	}
+
+	// 2. Re-validate against the donor's true surplus
+	var donorInv models.Inventory
+	if err := tx.Where("facility_id = ? AND item_id = ?", donor.ID, spec.ItemID).First(&donorInv).Error; err != nil {
+		return nil, fmt.Errorf("%w: %s does not stock item %s", ErrInsufficientStock, donor.Name, spec.ItemID)
+	}
+	if surplus := donorInv.TrueSurplus(SurplusForecastDays); surplus < qty {
+		return nil, fmt.Errorf("%w: %s has a true surplus of %d, %d requested", ErrInsufficientStock, donor.Name, surplus, qty)
+	}
+
+	// 3. Reject the original
+	if err := tx.Model(card).Update("status", models.CardRejected).Error; err != nil {
+		return nil, fmt.Errorf("failed to update card status: %w", err)
+	}
+
+	// 4. Create the successor, keeping lineage
+	payload := *NormalizeCardPayload(tx, card)
+	payload.SourceFacilityID = donor.ID
+	payload.SourceFacilityName = donor.Name
+	payload.ItemID = spec.ItemID
+	payload.Quantity = qty
+
+	// One successor per rejected card, however often the form is resubmitted
+	token := cardToken("counterproposal:" + card.ID)
+	successor := models.SolutionCard{
+		ID:                 uuid.New().String(),
+		Status:             models.CardPending,
+		CreatedAt:          time.Now(),
+		PriorityScore:      card.PriorityScore,
+		ConfidenceScore:    card.ConfidenceScore,
+		AIRationaleSummary: fmt.Sprintf("Reviewer counter-proposal: %d units from %s instead of the AI suggestion", qty, donor.Name),
+		Source:             models.CardSourceHuman,
+		ActionsRecommended: card.ActionsRecommended,
+		ParentCardID:       &card.ID,
+		IdempotencyToken:   &token,
+	}
+	if err := SetCardPayload(tx, &successor, payload); err != nil {
+		return nil, err
+	}
+	if err := tx.Create(&successor).Error; err != nil {
+		return nil, fmt.Errorf("failed to create successor card: %w", err)
+	}
+
+	// 5. History on both cards
+	if err := RecordCardEvent(tx, card.ID, CardChange{
+		Action:     models.CardActionRejected,
+		FromStatus: models.CardPending,
+		ToStatus:   models.CardRejected,
+		ActorID:    in.ActorID,
+		Note:       "Counter-proposed as card " + successor.ID,
+	}); err != nil {
+		return nil, err
+	}
+	if err := RecordCardEvent(tx, successor.ID, CardChange{
+		Action:   models.CardActionCreated,
+		ToStatus: models.CardPending,
+		ActorID:  in.ActorID,
+		Note:     fmt.Sprintf("Counter-proposal to card %s: %d units from %s", card.ID, qty, donor.Name),
+	}); err != nil {
+		return nil, err
+	}
+
+	return &CounterproposalResult{
+		Status:          "success",
+		Message:         "Transfer rejected with counter-proposal",
+		RejectedCardID:  card.ID,
+		SuccessorCardID: successor.ID,
+		DonorFacilityID: donor.ID,
+		Quantity:        qty,
+	}, nil
+}
+
+// findDonorFacility looks a reviewer's donor up by ID, or else by exact
+// name ignoring case. A name shared by several facilities is refused
+// rather than guessed.
+func findDonorFacility(tx *gorm.DB, ref string) (*models.Facility, error) {
+	var byID models.Facility
+	if err := tx.First(&byID, "id = ?", ref).Error; err == nil {
+		return &byID, nil
+	}
+	var named []models.Facility
+	if err := tx.Where("LOWER(name) = LOWER(?)", ref).Limit(2).Find(&named).Error; err != nil {
+		return nil, err
+	}
+	switch len(named) {
+	case 0:
+		return nil, fmt.Errorf("%w: unknown donor facility %q", ErrInvalidInput, ref)
+	case 1:
+		return &named[0], nil
+	}
+	return nil, fmt.Errorf("%w: several facilities are named %q; give the facility ID", ErrInvalidInput, ref)
+}
+
+// cardToken turns a natural key into a solution card idempotency token. It
+// is a name-based UUID (v5, URL namespace) like the tokens the ML service
+// writes, so both fit the same column.
+func cardToken(name string) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(name)).String()
+}
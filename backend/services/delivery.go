@@ -0,0 +1,314 @@
+package services
+
+import (
+	"backend/models"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Condition of goods reported by the receiving PHC.
+const (
+	ConditionGood    = "GOOD"
+	ConditionDamaged = "DAMAGED"
+)
+
+// ReceivedLine is one batch (or, for unbatched transfers, the whole
+// consignment) as counted at the recipient.
+type ReceivedLine struct {
+	BatchID          string `json:"batch_id"`
+	ReceivedQuantity int    `json:"received_quantity"`
+	Condition        string `json:"condition"`
+}
+
+// DeliveryConfirmation is what the receiving staff enter at handover.
+// Condition applies to every line that does not give its own.
+type DeliveryConfirmation struct {
+	ActorID    string
+	FacilityID string
+	Lines      []ReceivedLine
+	Condition  string
+	Note       string
+}
+
+// DeliveryReport summarises the reconciliation of a delivery.
+type DeliveryReport struct {
+	TransferID string `json:"transfer_id"`
+	Expected   int    `json:"expected"`
+	Received   int    `json:"received"`
+	Damaged    int    `json:"damaged"`
+	Missing    int    `json:"missing"`
+	Excess     int    `json:"excess"`
+	Compliance bool   `json:"compliance_logged"`
+}
+
+// ConfirmDelivery closes a transfer with the quantities actually received.
+// The recipient is credited with what was shipped, then damaged and
+// missing units are written off the ledger and a ComplianceLog entry is
+// raised so the loss shows up in the SOP violation report.
+func ConfirmDelivery(tx *gorm.DB, transferID string, in DeliveryConfirmation) (*DeliveryReport, error) {
+	var transfer models.Transfer
+	if err := tx.First(&transfer, "id = ?", transferID).Error; err != nil {
+		return nil, fmt.Errorf("%w: transfer %s", ErrNotFound, transferID)
+	}
+	if transfer.ConsignmentID != nil {
+		return nil, fmt.Errorf("%w: transfer is part of consignment %s; confirm the consignment", ErrInvalidInput, *transfer.ConsignmentID)
+	}
+	if hold := custodyOf(tx, &transfer); hold.Leg < hold.Legs {
+		return nil, fmt.Errorf("%w: transfer is on leg %d of %d and has not reached the last hub", ErrInvalidTransition, hold.Leg, hold.Legs)
+	}
+	if in.FacilityID == "" || in.FacilityID != transfer.ToFacilityID {
+		return nil, fmt.Errorf("%w: only the receiving facility can confirm this delivery", ErrForbidden)
+	}
+
+	tally, err := tallyDelivery(&transfer, in.Lines, in.Condition)
+	if err != nil {
+		return nil, err
+	}
+
+	// Close the transfer (credits the recipient with the shipped quantity)
+	if _, err := TransitionTransfer(tx, transfer.ID, models.TransferDelivered, TransitionInput{ActorID: in.ActorID, Note: deliveryNote(in)}); err != nil {
+		return nil, err
+	}
+	if err := settleDelivery(tx, &transfer, tally, in); err != nil {
+		return nil, err
+	}
+	return tally.report, nil
+}
+
+// ConsignmentReceivedLine is a ReceivedLine for one line of a consignment.
+type ConsignmentReceivedLine struct {
+	TransferID string `json:"transfer_id"`
+	ReceivedLine
+}
+
+// ConfirmConsignmentDelivery closes every line of a consignment at once,
+// reconciling each item as ConfirmDelivery does. Every line must be
+// counted, even if nothing of it arrived.
+func ConfirmConsignmentDelivery(tx *gorm.DB, consignmentID string, lines []ConsignmentReceivedLine, in DeliveryConfirmation) ([]*DeliveryReport, error) {
+	var c models.Consignment
+	if err := tx.Preload("Lines").First(&c, "id = ?", consignmentID).Error; err != nil {
+		return nil, fmt.Errorf("%w: consignment %s", ErrNotFound, consignmentID)
+	}
+	if in.FacilityID == "" || in.FacilityID != c.ToFacilityID {
+		return nil, fmt.Errorf("%w: only the receiving facility can confirm this delivery", ErrForbidden)
+	}
+
+	byTransfer := map[string][]ReceivedLine{}
+	for _, l := range lines {
+		byTransfer[l.TransferID] = append(byTransfer[l.TransferID], l.ReceivedLine)
+	}
+	tallies := make([]*deliveryTally, len(c.Lines))
+	for i := range c.Lines {
+		counted, ok := byTransfer[c.Lines[i].ID]
+		if !ok {
+			return nil, fmt.Errorf("%w: transfer %s has no received quantities", ErrInvalidInput, c.Lines[i].ID)
+		}
+		delete(byTransfer, c.Lines[i].ID)
+		tally, err := tallyDelivery(&c.Lines[i], counted, in.Condition)
+		if err != nil {
+			return nil, fmt.Errorf("transfer %s: %w", c.Lines[i].ID, err)
+		}
+		tallies[i] = tally
+	}
+	for id := range byTransfer {
+		return nil, fmt.Errorf("%w: transfer %q is not on this consignment", ErrInvalidInput, id)
+	}
+
+	if _, err := TransitionConsignment(tx, c.ID, models.TransferDelivered, TransitionInput{ActorID: in.ActorID, Note: deliveryNote(in)}); err != nil {
+		return nil, err
+	}
+	reports := make([]*DeliveryReport, len(c.Lines))
+	for i := range c.Lines {
+		if err := settleDelivery(tx, &c.Lines[i], tallies[i], in); err != nil {
+			return nil, err
+		}
+		reports[i] = tallies[i].report
+	}
+	return reports, nil
+}
+
+// deliveryTally is a reconciled count waiting to be booked.
+type deliveryTally struct {
+	report       *DeliveryReport
+	lost, broken models.BatchList
+}
+
+// tallyDelivery compares what was counted against what was shipped. Lines
+// without a condition of their own take the overall one.
+func tallyDelivery(transfer *models.Transfer, lines []ReceivedLine, condition string) (*deliveryTally, error) {
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("%w: received quantities are required", ErrInvalidInput)
+	}
+	overall, err := normalizeCondition(condition)
+	if err != nil {
+		return nil, err
+	}
+
+	shipped := shippedLines(transfer)
+	expected := map[string]int{}
+	for _, b := range shipped {
+		expected[b.BatchID] += b.Quantity
+	}
+	tally := &deliveryTally{report: &DeliveryReport{TransferID: transfer.ID, Expected: transfer.Quantity}}
+
+	// 1. Tally what arrived per batch
+	good := map[string]int{}
+	damaged := map[string]int{}
+	for _, line := range lines {
+		if _, ok := expected[line.BatchID]; !ok {
+			return nil, fmt.Errorf("%w: batch %q is not on this transfer", ErrInvalidInput, line.BatchID)
+		}
+		if line.ReceivedQuantity < 0 {
+			return nil, fmt.Errorf("%w: received quantity cannot be negative", ErrInvalidInput)
+		}
+		cond, err := normalizeCondition(line.Condition)
+		if err != nil {
+			return nil, err
+		}
+		if cond == "" {
+			cond = overall
+		}
+		if cond == ConditionDamaged {
+			damaged[line.BatchID] += line.ReceivedQuantity
+		} else {
+			good[line.BatchID] += line.ReceivedQuantity
+		}
+	}
+
+	// 2. Work out what has to be written off
+	for _, b := range shipped {
+		tally.lost, tally.broken = appendShortfall(tally.lost, tally.broken, b, good[b.BatchID], damaged[b.BatchID], tally.report)
+		delete(good, b.BatchID)
+		delete(damaged, b.BatchID)
+	}
+	return tally, nil
+}
+
+// normalizeCondition upper-cases a reported condition and refuses anything
+// but GOOD, DAMAGED or blank.
+func normalizeCondition(condition string) (string, error) {
+	switch c := strings.ToUpper(strings.TrimSpace(condition)); c {
+	case "", ConditionGood, ConditionDamaged:
+		return c, nil
+	}
+	return "", fmt.Errorf("%w: unknown condition %q", ErrInvalidInput, condition)
+}
+
+// settleDelivery books a tally on a transfer that has just been delivered:
+// proof of delivery, write-offs and the SOP violation if anything is off.
+func settleDelivery(tx *gorm.DB, transfer *models.Transfer, tally *deliveryTally, in DeliveryConfirmation) error {
+	report := tally.report
+	condition := ConditionGood
+	if report.Damaged > 0 {
+		condition = ConditionDamaged
+	}
+	if err := tx.Model(transfer).Updates(map[string]interface{}{
+		"received_quantity":  report.Received,
+		"delivery_condition": condition,
+		"delivery_note":      in.Note,
+	}).Error; err != nil {
+		return err
+	}
+
+	if report.Missing > 0 {
+		if err := WriteOffStock(tx, transfer.ToFacilityID, transfer.ItemID, report.Missing, tally.lost, "transit_loss"); err != nil {
+			return err
+		}
+	}
+	if report.Damaged > 0 {
+		if err := WriteOffStock(tx, transfer.ToFacilityID, transfer.ItemID, report.Damaged, tally.broken, "transit_damage"); err != nil {
+			return err
+		}
+	}
+	if report.Missing > 0 || report.Damaged > 0 || report.Excess > 0 {
+		if err := logDeliveryViolation(tx, transfer, report, in); err != nil {
+			return err
+		}
+		report.Compliance = true
+	}
+	return nil
+}
+
+func deliveryNote(in DeliveryConfirmation) string {
+	if in.Note == "" {
+		return "Delivery confirmed"
+	}
+	return in.Note
+}
+
+// shippedLines lists the transfer's batches plus an unbatched line (ID "")
+// for any quantity the batch list does not cover.
+func shippedLines(t *models.Transfer) models.BatchList {
+	lines := append(models.BatchList{}, t.Batches...)
+	if rest := t.Quantity - SumBatches(t.Batches); rest > 0 {
+		lines = append(lines, models.Batch{Quantity: rest})
+	}
+	return lines
+}
+
+func appendShortfall(lost, broken models.BatchList, b models.Batch, good, damaged int, report *DeliveryReport) (models.BatchList, models.BatchList) {
+	expected := b.Quantity
+	// Anything over the shipped quantity is not credited, only reported
+	if good+damaged > expected {
+		report.Excess += good + damaged - expected
+		if damaged > expected {
+			damaged = expected
+		}
+		good = expected - damaged
+	}
+	missing := expected - good - damaged
+
+	report.Received += good
+	report.Damaged += damaged
+	report.Missing += missing
+
+	if missing > 0 {
+		m := b
+		m.Quantity = missing
+		lost = append(lost, m)
+	}
+	if damaged > 0 {
+		d := b
+		d.Quantity = damaged
+		broken = append(broken, d)
+	}
+	return lost, broken
+}
+
+func logDeliveryViolation(tx *gorm.DB, t *models.Transfer, r *DeliveryReport, in DeliveryConfirmation) error {
+	var item models.Item
+	tx.Select("name").First(&item, "id = ?", t.ItemID)
+	name := item.Name
+	if name == "" {
+		name = t.ItemID
+	}
+
+	var problems []string
+	if r.Missing > 0 {
+		problems = append(problems, fmt.Sprintf("%d short", r.Missing))
+	}
+	if r.Damaged > 0 {
+		problems = append(problems, fmt.Sprintf("%d damaged", r.Damaged))
+	}
+	if r.Excess > 0 {
+		problems = append(problems, fmt.Sprintf("%d over manifest", r.Excess))
+	}
+
+	action := fmt.Sprintf("Recipient credited %d of %d; %d written off", r.Received, r.Expected, r.Missing+r.Damaged)
+	if in.Note != "" {
+		action += ". Note: " + in.Note
+	}
+
+	return tx.Create(&models.ComplianceLog{
+		ID:               uuid.New().String(),
+		CreatedAt:        time.Now(),
+		FacilityID:       t.ToFacilityID,
+		UserID:           in.ActorID,
+		ViolationDetails: fmt.Sprintf("Delivery mismatch: %s %s (transfer %s)", name, strings.Join(problems, ", "), t.ID),
+		ActionTaken:      action,
+	}).Error
+}
@@ -0,0 +1,295 @@
+package services
+
+import (
+	"backend/models"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DriverCandidate is a driver (with their vehicle) ranked for a pickup at
+// a donor facility.
+type DriverCandidate struct {
+	DriverID        string   `json:"driver_id"`
+	Name            string   `json:"name"`
+	Email           string   `json:"email"`
+	VehicleID       string   `json:"vehicle_id"`
+	Registration    string   `json:"registration"`
+	VehicleType     string   `json:"vehicle_type"`
+	ActiveTransfers int64    `json:"active_transfers"`
+	DistanceKm      *float64 `json:"distance_km"`
+}
+
+// RankDrivers lists on-shift drivers in the donor's district whose vehicle
+// can carry qty units (refrigerated, if coldChain) and who still have
+// capacity, least busy first and then nearest to the donor. Distance is
+// measured from the driver's home facility.
+func RankDrivers(tx *gorm.DB, donorFacilityID string, qty int, coldChain bool) ([]DriverCandidate, error) {
+	var donor models.Facility
+	if err := tx.First(&donor, "id = ?", donorFacilityID).Error; err != nil {
+		return nil, fmt.Errorf("%w: facility %s", ErrNotFound, donorFacilityID)
+	}
+
+	var drivers []models.User
+	if err := tx.Where("role = ? AND district = ? AND on_duty = ?", RoleDriver, donor.District, true).
+		Find(&drivers).Error; err != nil {
+		return nil, err
+	}
+	if len(drivers) == 0 {
+		return []DriverCandidate{}, nil
+	}
+
+	// Workload: transfers assigned and not yet delivered
+	ids := make([]string, 0, len(drivers))
+	for _, d := range drivers {
+		ids = append(ids, d.ID)
+	}
+
+	// Only drivers with an active vehicle can be dispatched
+	var vehicles []models.Vehicle
+	if err := tx.Where("driver_id IN ? AND active = ?", ids, true).Find(&vehicles).Error; err != nil {
+		return nil, err
+	}
+	vehicleOf := make(map[string]models.Vehicle, len(vehicles))
+	for _, v := range vehicles {
+		vehicleOf[*v.DriverID] = v
+	}
+
+	workload := driverWorkload(tx, ids)
+
+	facilityIDs := []string{donor.ID}
+	for _, d := range drivers {
+		if d.FacilityID != nil {
+			facilityIDs = append(facilityIDs, *d.FacilityID)
+		}
+	}
+	coords := FacilityCoords(tx, facilityIDs...)
+	donorAt, donorKnown := coords[donor.ID]
+
+	maxActive := int64(DistrictSettingInt(tx, donor.District, "dispatch_max_active_transfers", 3))
+	now := time.Now()
+
+	candidates := make([]DriverCandidate, 0, len(drivers))
+	for _, d := range drivers {
+		v, hasVehicle := vehicleOf[d.ID]
+		if !hasVehicle || !v.CanCarry(qty) || (coldChain && !v.ColdChain) || !onShift(d, now) || workload[d.ID] >= maxActive {
+			continue
+		}
+		c := DriverCandidate{
+			DriverID:        d.ID,
+			Name:            d.Name,
+			Email:           d.Email,
+			VehicleID:       v.ID,
+			Registration:    v.Registration,
+			VehicleType:     v.Type,
+			ActiveTransfers: workload[d.ID],
+		}
+		if d.FacilityID != nil && donorKnown {
+			if home, ok := coords[*d.FacilityID]; ok {
+				km := DistanceKm(home, donorAt)
+				c.DistanceKm = &km
+			}
+		}
+		candidates = append(candidates, c)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.ActiveTransfers != b.ActiveTransfers {
+			return a.ActiveTransfers < b.ActiveTransfers
+		}
+		if (a.DistanceKm == nil) != (b.DistanceKm == nil) {
+			return a.DistanceKm != nil
+		}
+		return a.DistanceKm != nil && *a.DistanceKm < *b.DistanceKm
+	})
+	return candidates, nil
+}
+
+// driverWorkload counts the transfers each driver is assigned and has not
+// yet delivered. Hub-routed transfers count once per leg a driver holds.
+func driverWorkload(tx *gorm.DB, driverIDs []string) map[string]int64 {
+	var loads []struct {
+		DriverID string
+		Count    int64
+	}
+	tx.Model(&models.Transfer{}).
+		Select("driver_id, COUNT(*) as count").
+		Where("driver_id IN ? AND status IN ?", driverIDs, models.DriverWorkloadStatuses).
+		Where("NOT EXISTS (SELECT 1 FROM transfer_legs l WHERE l.transfer_id = transfers.id)").
+		Group("driver_id").
+		Scan(&loads)
+	workload := make(map[string]int64, len(loads))
+	for _, l := range loads {
+		workload[l.DriverID] = l.Count
+	}
+	loads = nil
+	tx.Model(&models.TransferLeg{}).
+		Select("driver_id, COUNT(*) as count").
+		Where("driver_id IN ? AND status IN ?", driverIDs, models.DriverWorkloadStatuses).
+		Group("driver_id").
+		Scan(&loads)
+	for _, l := range loads {
+		workload[l.DriverID] += l.Count
+	}
+	return workload
+}
+
+// SelectDriver returns the best driver for a pickup, or nil when nobody is free.
+func SelectDriver(tx *gorm.DB, donorFacilityID string, qty int, coldChain bool) (*DriverCandidate, error) {
+	candidates, err := RankDrivers(tx, donorFacilityID, qty, coldChain)
+	if err != nil || len(candidates) == 0 {
+		return nil, err
+	}
+	return &candidates[0], nil
+}
+
+// driverWithVehicle builds a candidate for a manually chosen driver,
+// holding them to the same rules as RankDrivers: the pickup's district,
+// on duty and on shift, a vehicle that can take the load and room in their
+// workload. current is the driver the trip has now; keeping them does not
+// count against their workload twice.
+func driverWithVehicle(tx *gorm.DB, pickupFacilityID, driverID string, current *string, qty int, coldChain bool) (*DriverCandidate, error) {
+	var d models.User
+	if err := tx.First(&d, "id = ? AND role = ?", driverID, RoleDriver).Error; err != nil {
+		return nil, fmt.Errorf("%w: driver %s", ErrNotFound, driverID)
+	}
+	district := facilityDistrict(tx, pickupFacilityID)
+	if d.District != district {
+		return nil, fmt.Errorf("%w: driver %s works in %s, not %s", ErrInvalidInput, d.Email, d.District, district)
+	}
+	if !d.OnDuty || !onShift(d, time.Now()) {
+		return nil, fmt.Errorf("%w: driver %s is not on shift", ErrInvalidInput, d.Email)
+	}
+	if current == nil || *current != d.ID {
+		maxActive := int64(DistrictSettingInt(tx, district, "dispatch_max_active_transfers", 3))
+		if load := driverWorkload(tx, []string{d.ID})[d.ID]; load >= maxActive {
+			return nil, fmt.Errorf("%w: driver %s already has %d active transfers", ErrInvalidInput, d.Email, load)
+		}
+	}
+	var v models.Vehicle
+	if err := tx.Where("driver_id = ? AND active = ?", d.ID, true).First(&v).Error; err != nil {
+		return nil, fmt.Errorf("%w: driver %s has no vehicle assigned", ErrInvalidInput, d.Email)
+	}
+	if !v.CanCarry(qty) {
+		return nil, fmt.Errorf("%w: vehicle %s carries at most %d units", ErrInvalidInput, v.Registration, v.PayloadCapacity)
+	}
+	if coldChain && !v.ColdChain {
+		return nil, fmt.Errorf("%w: vehicle %s is not refrigerated", ErrInvalidInput, v.Registration)
+	}
+	return &DriverCandidate{
+		DriverID:     d.ID,
+		Name:         d.Name,
+		Email:        d.Email,
+		VehicleID:    v.ID,
+		Registration: v.Registration,
+		VehicleType:  v.Type,
+	}, nil
+}
+
+// AssignDriver (re)assigns a transfer that has not been picked up yet.
+// An empty driverID lets dispatch choose; if nobody is free the transfer
+// waits in AWAITING_DRIVER.
+func AssignDriver(tx *gorm.DB, transferID, driverID, actorID string) (*models.Transfer, error) {
+	// A consignment line reassigns the whole trip
+	var head models.Transfer
+	if err := tx.Select("id, consignment_id").First(&head, "id = ?", transferID).Error; err != nil {
+		return nil, fmt.Errorf("%w: transfer %s", ErrNotFound, transferID)
+	}
+	if head.ConsignmentID != nil {
+		c, err := assignConsignmentDriver(tx, *head.ConsignmentID, driverID, actorID)
+		if err != nil {
+			return nil, err
+		}
+		for i := range c.Lines {
+			if c.Lines[i].ID == transferID {
+				return &c.Lines[i], nil
+			}
+		}
+		return nil, fmt.Errorf("%w: transfer %s", ErrNotFound, transferID)
+	}
+
+	var transfer models.Transfer
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&transfer, "id = ?", transferID).Error; err != nil {
+		return nil, fmt.Errorf("%w: transfer %s", ErrNotFound, transferID)
+	}
+	legs, err := lockLegs(tx, transfer.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(legs) > 0 {
+		return assignLegDriver(tx, &transfer, legs, driverID, actorID)
+	}
+	if transfer.Status != models.TransferApproved && transfer.Status != models.TransferAwaitingDriver {
+		return nil, fmt.Errorf("%w: driver cannot be changed while transfer is %s", ErrInvalidTransition, transfer.Status)
+	}
+
+	var driver *DriverCandidate
+	if driverID != "" {
+		driver, err = driverWithVehicle(tx, transfer.FromFacilityID, driverID, transfer.DriverID, transfer.Quantity, transfer.ColdChain)
+	} else {
+		driver, err = SelectDriver(tx, transfer.FromFacilityID, transfer.Quantity, transfer.ColdChain)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	target := models.TransferAwaitingDriver
+	note := "No driver available"
+	if driver != nil {
+		target = models.TransferApproved
+		note = "Driver assigned: " + driver.Email
+	}
+
+	updates := map[string]interface{}{"updated_at": time.Now()}
+	if driver != nil {
+		updates["driver_id"] = driver.DriverID
+		updates["vehicle_id"] = driver.VehicleID
+		updates["vehicle_type"] = driver.VehicleType
+		updates["vehicle_number"] = driver.Registration
+		// A different vehicle travels at a different speed
+		transfer.VehicleType = driver.VehicleType
+		if eta := EstimateArrivalAtApproval(tx, &transfer, time.Now()); eta != nil {
+			updates["estimated_arrival_time"] = eta
+		}
+	} else {
+		updates["driver_id"] = nil
+		updates["vehicle_id"] = nil
+	}
+	if err := tx.Model(&transfer).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+
+	if target == transfer.Status {
+		if err := recordTransferEvent(tx, transfer.ID, transfer.Status, target, TransitionInput{ActorID: actorID, Note: note}); err != nil {
+			return nil, err
+		}
+		if err := tx.First(&transfer, "id = ?", transfer.ID).Error; err != nil {
+			return nil, err
+		}
+		return &transfer, nil
+	}
+	return TransitionTransfer(tx, transfer.ID, target, TransitionInput{ActorID: actorID, Note: note})
+}
+
+// onShift checks the optional "HH:MM" shift window (overnight shifts wrap).
+func onShift(u models.User, now time.Time) bool {
+	if u.ShiftStart == "" || u.ShiftEnd == "" {
+		return true
+	}
+	start, err1 := time.Parse("15:04", u.ShiftStart)
+	end, err2 := time.Parse("15:04", u.ShiftEnd)
+	if err1 != nil || err2 != nil {
+		return true
+	}
+	minutes := now.Hour()*60 + now.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+	if from <= to {
+		return minutes >= from && minutes < to
+	}
+	return minutes >= from || minutes < to
+}
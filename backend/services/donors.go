@@ -0,0 +1,226 @@
+package services
+
+import (
+	"backend/models"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DonorQuery asks who can give `Quantity` of an item to a facility.
+type DonorQuery struct {
+	FacilityID string // Recipient
+	ItemID     string
+	ItemName   string // Used when ItemID is empty
+	Quantity   int
+	Limit      int // 0 means 5, like the ML agent
+}
+
+// DonorCandidate is a facility able to cover the whole request.
+type DonorCandidate struct {
+	FacilityID  string   `json:"facility_id"`
+	Name        string   `json:"name"`
+	District    string   `json:"district"`
+	Available   int      `json:"available"`
+	TrueSurplus int      `json:"true_surplus"`
+	DistanceKm  *float64 `json:"distance_km"`
+}
+
+// Recommendation is the ranked donors for one request.
+type Recommendation struct {
+	RecipientID   string           `json:"recipient_id"`
+	RecipientName string           `json:"recipient_name"`
+	ItemID        string           `json:"item_id"`
+	ItemName      string           `json:"item_name"`
+	Quantity      int              `json:"quantity"`
+	Candidates    []DonorCandidate `json:"candidates"`
+}
+
+// FindDonors ranks facilities whose true surplus (available stock minus
+// safety stock and SurplusForecastDays of consumption, the ML agent's rule)
+// covers the request. Nearest donors come first; equal or unknown
+// distances fall back to the larger surplus.
+func FindDonors(tx *gorm.DB, q DonorQuery) (*Recommendation, error) {
+	if q.Quantity <= 0 {
+		return nil, fmt.Errorf("%w: quantity must be positive", ErrInvalidInput)
+	}
+	if q.Limit <= 0 {
+		q.Limit = 5
+	}
+
+	var recipient models.Facility
+	if err := tx.First(&recipient, "id = ?", q.FacilityID).Error; err != nil {
+		return nil, fmt.Errorf("%w: facility %s", ErrNotFound, q.FacilityID)
+	}
+	item, err := findItem(tx, q.ItemID, q.ItemName)
+	if err != nil {
+		return nil, err
+	}
+
+	var stock []models.Inventory
+	if err := tx.Preload("Facility").
+		Where("item_id = ? AND facility_id <> ?", item.ID, recipient.ID).
+		Find(&stock).Error; err != nil {
+		return nil, err
+	}
+
+	ids := []string{recipient.ID}
+	for _, inv := range stock {
+		ids = append(ids, inv.FacilityID)
+	}
+	coords := FacilityCoords(tx, ids...)
+	here, located := coords[recipient.ID]
+
+	candidates := []DonorCandidate{}
+	for _, inv := range stock {
+		surplus := inv.TrueSurplus(SurplusForecastDays)
+		if surplus < q.Quantity {
+			continue
+		}
+		c := DonorCandidate{
+			FacilityID:  inv.FacilityID,
+			Name:        inv.Facility.Name,
+			District:    inv.Facility.District,
+			Available:   inv.Available(),
+			TrueSurplus: surplus,
+		}
+		if there, ok := coords[inv.FacilityID]; ok && located {
+			km := math.Round(DistanceKm(here, there)*10) / 10
+			c.DistanceKm = &km
+		}
+		candidates = append(candidates, c)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if (a.DistanceKm == nil) != (b.DistanceKm == nil) {
+			return a.DistanceKm != nil
+		}
+		if a.DistanceKm != nil && *a.DistanceKm != *b.DistanceKm {
+			return *a.DistanceKm < *b.DistanceKm
+		}
+		return a.TrueSurplus > b.TrueSurplus
+	})
+	if len(candidates) > q.Limit {
+		candidates = candidates[:q.Limit]
+	}
+
+	return &Recommendation{
+		RecipientID:   recipient.ID,
+		RecipientName: recipient.Name,
+		ItemID:        item.ID,
+		ItemName:      item.Name,
+		Quantity:      q.Quantity,
+		Candidates:    candidates,
+	}, nil
+}
+
+// CreateRulesCard persists the top candidate as a pending card with
+// Source RULES. The same recommendation on the same day returns the
+// existing card instead of a duplicate while that card is still pending;
+// once it has been decided or has expired a new card is raised.
+func CreateRulesCard(tx *gorm.DB, rec *Recommendation, vehicleType string) (*models.SolutionCard, error) {
+	if len(rec.Candidates) == 0 {
+		return nil, fmt.Errorf("%w: no donor can cover %d units of %s", ErrInsufficientStock, rec.Quantity, rec.ItemName)
+	}
+	donor := rec.Candidates[0]
+	if vehicleType == "" {
+		vehicleType = models.VehicleVan
+	}
+
+	rationale := fmt.Sprintf("Rules engine: %s has a %d-day true surplus of %d units", donor.Name, SurplusForecastDays, donor.TrueSurplus)
+	if donor.DistanceKm != nil {
+		rationale += fmt.Sprintf(", %.1f km away", *donor.DistanceKm)
+	}
+
+	card := models.SolutionCard{
+		ID:                 uuid.New().String(),
+		Status:             models.CardPending,
+		CreatedAt:          time.Now(),
+		PriorityScore:      recipientPriority(tx, rec.RecipientID, rec.ItemID),
+		AIRationaleSummary: rationale,
+		Source:             models.CardSourceRules,
+	}
+	if err := SetCardPayload(tx, &card, models.CardPayload{
+		SourceFacilityID:        donor.FacilityID,
+		SourceFacilityName:      donor.Name,
+		DestinationFacilityID:   rec.RecipientID,
+		DestinationFacilityName: rec.RecipientName,
+		ItemID:                  rec.ItemID,
+		ItemName:                rec.ItemName,
+		Quantity:                rec.Quantity,
+		TransportMode:           strings.ToUpper(vehicleType),
+	}); err != nil {
+		return nil, err
+	}
+
+	// A decided card's token is taken, so its successor's token is derived
+	// from it; retries walk the same chain and land on the same card
+	name := fmt.Sprintf("rules:%s:%s:%s:%d:%s", rec.RecipientID, donor.FacilityID, rec.ItemID, rec.Quantity, time.Now().Format("2006-01-02"))
+	for {
+		token := cardToken(name)
+		card.IdempotencyToken = &token
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&card)
+		if result.Error != nil {
+			return nil, fmt.Errorf("failed to create card: %w", result.Error)
+		}
+		if result.RowsAffected == 1 {
+			break
+		}
+		var existing models.SolutionCard
+		if err := tx.First(&existing, "idempotency_token = ?", token).Error; err != nil {
+			return nil, err
+		}
+		if existing.Status == models.CardPending {
+			return &existing, nil
+		}
+		name += ":after:" + existing.ID
+	}
+	if err := RecordCardEvent(tx, card.ID, CardChange{
+		Action:   models.CardActionCreated,
+		ToStatus: models.CardPending,
+		Note:     rationale,
+	}); err != nil {
+		return nil, err
+	}
+	return &card, nil
+}
+
+// recipientPriority scores urgency from the recipient's days of cover.
+func recipientPriority(tx *gorm.DB, facilityID, itemID string) int {
+	var inv models.Inventory
+	if err := tx.Where("facility_id = ? AND item_id = ?", facilityID, itemID).First(&inv).Error; err != nil || inv.ConsumptionRate <= 0 {
+		return 5
+	}
+	switch days := float64(inv.Quantity) / inv.ConsumptionRate; {
+	case days < 1:
+		return 9
+	case days < 3:
+		return 7
+	default:
+		return 5
+	}
+}
+
+func findItem(tx *gorm.DB, id, name string) (*models.Item, error) {
+	var item models.Item
+	switch {
+	case id != "":
+		if err := tx.First(&item, "id = ?", id).Error; err != nil {
+			return nil, fmt.Errorf("%w: item %s", ErrNotFound, id)
+		}
+	case name != "":
+		if err := tx.Where("name ILIKE ? OR generic_name ILIKE ?", name, name).First(&item).Error; err != nil {
+			return nil, fmt.Errorf("%w: item %q", ErrNotFound, name)
+		}
+	default:
+		return nil, fmt.Errorf("%w: item_id or item_name is required", ErrInvalidInput)
+	}
+	return &item, nil
+}
@@ -0,0 +1,15 @@
+package services
+
+import "errors"
+
+// Sentinel errors returned by the domain services. Controllers map them
+// to HTTP status codes; wrap them with fmt.Errorf("%w: ...") for detail.
+var (
+	ErrNotFound          = errors.New("not found")
+	ErrInvalidTransition = errors.New("invalid status transition")
+	ErrInvalidInput      = errors.New("invalid input")
+	ErrForbidden         = errors.New("not permitted")
+	ErrAlreadyProcessed  = errors.New("request already processed")
+	ErrInsufficientStock = errors.New("insufficient stock at donor facility")
+	ErrJobRunning        = errors.New("job is already running")
+)
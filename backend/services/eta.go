@@ -0,0 +1,72 @@
+package services
+
+import (
+	"backend/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Average road speed per vehicle type in city traffic (km/h).
+var vehicleSpeedKmh = map[string]float64{
+	models.VehicleBike:  25,
+	models.VehicleVan:   30,
+	models.VehicleTruck: 22,
+}
+
+const (
+	defaultSpeedKmh = 25
+	// Roads are longer than the straight line between two PHCs
+	roadDetourFactor = 1.4
+)
+
+// EstimateArrival predicts when a transfer reaches its recipient if it
+// leaves `origin` at `departure`. A nil origin means the donor facility.
+// Travel time is stretched by the district's weather multiplier and, when
+// monsoon mode is on, the monsoon multiplier. Returns nil when the
+// facilities have no coordinates.
+func EstimateArrival(tx *gorm.DB, t *models.Transfer, departure time.Time, origin *Coord) *time.Time {
+	coords := FacilityCoords(tx, t.FromFacilityID, t.ToFacilityID)
+	dest, ok := coords[t.ToFacilityID]
+	if !ok {
+		return nil
+	}
+	start := origin
+	if start == nil {
+		from, ok := coords[t.FromFacilityID]
+		if !ok {
+			return nil
+		}
+		start = &from
+	}
+
+	speed, ok := vehicleSpeedKmh[strings.ToUpper(t.VehicleType)]
+	if !ok {
+		speed = defaultSpeedKmh
+	}
+	hours := DistanceKm(*start, dest) * roadDetourFactor / speed
+	hours *= travelMultiplier(tx, t.FromFacilityID)
+
+	eta := departure.Add(time.Duration(hours * float64(time.Hour)))
+	return &eta
+}
+
+// EstimateArrivalAtApproval allows for the wait before the driver collects.
+func EstimateArrivalAtApproval(tx *gorm.DB, t *models.Transfer, approvedAt time.Time) *time.Time {
+	district := facilityDistrict(tx, t.FromFacilityID)
+	buffer := DistrictSettingInt(tx, district, "eta_pickup_buffer_minutes", 30)
+	return EstimateArrival(tx, t, approvedAt.Add(time.Duration(buffer)*time.Minute), nil)
+}
+
+func travelMultiplier(tx *gorm.DB, facilityID string) float64 {
+	district := facilityDistrict(tx, facilityID)
+	m := DistrictSettingFloat(tx, district, "eta_weather_multiplier", 1.0)
+	if strings.EqualFold(DistrictSetting(tx, district, "monsoon_mode", "false"), "true") {
+		m *= DistrictSettingFloat(tx, district, "eta_monsoon_multiplier", 1.5)
+	}
+	if m <= 0 {
+		return 1
+	}
+	return m
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"math"
+
+	"gorm.io/gorm"
+)
+
+// Coord is a WGS84 point.
+type Coord struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// FacilityCoords reads facility locations from the PostGIS `location`
+// column. Facilities without a location are simply missing from the map.
+func FacilityCoords(tx *gorm.DB, ids ...string) map[string]Coord {
+	var rows []struct {
+		ID  string
+		Lat float64
+		Lng float64
+	}
+	tx.Raw(`
+		SELECT id, ST_Y(location::geometry) AS lat, ST_X(location::geometry) AS lng
+		FROM facilities
+		WHERE id IN ? AND location IS NOT NULL
+	`, ids).Scan(&rows)
+
+	coords := make(map[string]Coord, len(rows))
+	for _, r := range rows {
+		coords[r.ID] = Coord{Lat: r.Lat, Lng: r.Lng}
+	}
+	return coords
+}
+
+// DistanceKm is the great-circle distance between two points.
+func DistanceKm(a, b Coord) float64 {
+	const earthRadiusKm = 6371.0
+	rad := math.Pi / 180
+	dLat := (b.Lat - a.Lat) * rad
+	dLng := (b.Lng - a.Lng) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(a.Lat*rad)*math.Cos(b.Lat*rad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"backend/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// IdempotencyTTL is how long a stored response is replayed for a repeated
+// Idempotency-Key.
+const IdempotencyTTL = 24 * time.Hour
+
+// DefaultJobRunRetentionDays is how long job run history is kept unless the
+// GLOBAL setting "job_runs.retention_days" says otherwise.
+const DefaultJobRunRetentionDays = 14
+
+// HousekeepingSummary is what a housekeeping run cleared out.
+type HousekeepingSummary struct {
+	IdempotencyRecords int64 `json:"idempotency_records"`
+	JobRuns            int64 `json:"job_runs"`
+}
+
+// Housekeeping deletes records that are past their retention. The latest
+// run of each job and district is always kept; the scheduler reads it to
+// decide when the job is next due.
+func Housekeeping(db *gorm.DB, now time.Time) (*HousekeepingSummary, error) {
+	summary := &HousekeepingSummary{}
+	result := db.Where("created_at < ?", now.Add(-IdempotencyTTL)).Delete(&models.IdempotencyRecord{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	summary.IdempotencyRecords = result.RowsAffected
+
+	days := DistrictSettingInt(db, "GLOBAL", "job_runs.retention_days", DefaultJobRunRetentionDays)
+	result = db.Where("started_at < ? AND status <> ?", now.AddDate(0, 0, -days), models.JobRunning).
+		Where("id NOT IN (SELECT DISTINCT ON (job, district) id FROM job_runs ORDER BY job, district, started_at DESC)").
+		Delete(&models.JobRun{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	summary.JobRuns = result.RowsAffected
+	return summary, nil
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"backend/models"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// JobLease is how long a running job blocks new runs of the same job for
+// the same district. A run still marked running after that is assumed to
+// belong to a process that died and is closed as failed.
+const JobLease = 2 * time.Hour
+
+// BeginJobRun records the start of a run, or returns ErrJobRunning when
+// another run of the job for the district has not finished. The check and
+// insert hold a Postgres advisory lock so two server instances cannot both
+// start the same run.
+func BeginJobRun(db *gorm.DB, job, district, trigger, actorID string) (*models.JobRun, error) {
+	var run *models.JobRun
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "job:"+job+":"+district).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Model(&models.JobRun{}).
+			Where("job = ? AND district = ? AND status = ? AND started_at <= ?", job, district, models.JobRunning, now.Add(-JobLease)).
+			Updates(map[string]interface{}{"status": models.JobFailed, "finished_at": now, "error": "abandoned: lease expired"}).Error; err != nil {
+			return err
+		}
+
+		var running int64
+		if err := tx.Model(&models.JobRun{}).
+			Where("job = ? AND district = ? AND status = ?", job, district, models.JobRunning).
+			Count(&running).Error; err != nil {
+			return err
+		}
+		if running > 0 {
+			return fmt.Errorf("%w: %s for %s", ErrJobRunning, job, district)
+		}
+
+		run = &models.JobRun{
+			ID:          uuid.New().String(),
+			Job:         job,
+			District:    district,
+			Trigger:     trigger,
+			TriggeredBy: actorID,
+			Status:      models.JobRunning,
+			StartedAt:   now,
+		}
+		return tx.Create(run).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// FinishJobRun closes a run with the job's summary or error.
+func FinishJobRun(db *gorm.DB, run *models.JobRun, summary interface{}, runErr error) error {
+	now := time.Now()
+	run.FinishedAt = &now
+	run.Status = models.JobSucceeded
+	if runErr != nil {
+		run.Status = models.JobFailed
+		run.Error = runErr.Error()
+	}
+	if summary != nil {
+		if raw, err := json.Marshal(summary); err == nil {
+			json.Unmarshal(raw, &run.Summary)
+		}
+	}
+	return db.Model(run).Updates(map[string]interface{}{
+		"status":      run.Status,
+		"finished_at": run.FinishedAt,
+		"summary":     run.Summary,
+		"error":       run.Error,
+	}).Error
+}
+
+// LastJobRun is the most recent run of the job for the district, or nil.
+func LastJobRun(db *gorm.DB, job, district string) *models.JobRun {
+	var run models.JobRun
+	if err := db.Where("job = ? AND district = ?", job, district).Order("started_at DESC").First(&run).Error; err != nil {
+		return nil
+	}
+	return &run
+}
+
+// Districts lists every district that has at least one facility.
+func Districts(db *gorm.DB) ([]string, error) {
+	var districts []string
+	err := db.Model(&models.Facility{}).Where("district <> ''").Distinct("district").Order("district").Pluck("district", &districts).Error
+	return districts, err
+}
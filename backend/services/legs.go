@@ -0,0 +1,409 @@
+package services
+
+import (
+	"backend/models"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// HubFacilityTypes are the facilities a transfer may be routed through,
+// the same sources GetRobinHoodMetric treats as procurement.
+var HubFacilityTypes = []string{"Warehouse", "Medical College"}
+
+// lockLegs loads a transfer's legs FOR UPDATE in route order. Callers lock
+// the transfer row first.
+func lockLegs(tx *gorm.DB, transferID string) ([]models.TransferLeg, error) {
+	var legs []models.TransferLeg
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("transfer_id = ?", transferID).Order("sequence").Find(&legs).Error
+	return legs, err
+}
+
+// routeLegs reads a transfer's legs without locking; empty for direct transfers.
+func routeLegs(tx *gorm.DB, transferID string) []models.TransferLeg {
+	var legs []models.TransferLeg
+	tx.Where("transfer_id = ?", transferID).Order("sequence").Find(&legs)
+	return legs
+}
+
+// currentLeg is the first leg whose stock has not been handed over yet, or
+// nil once the last leg is delivered.
+func currentLeg(legs []models.TransferLeg) *models.TransferLeg {
+	for i := range legs {
+		if legs[i].Status != models.TransferDelivered {
+			return &legs[i]
+		}
+	}
+	return nil
+}
+
+// routedStatus derives a transfer's status from its legs: it waits with the
+// first leg, is PICKED_UP while the first driver holds it, IN_TRANSIT from
+// there until the last handover, and DELIVERED after it.
+func routedStatus(legs []models.TransferLeg) string {
+	if legs[len(legs)-1].Status == models.TransferDelivered {
+		return models.TransferDelivered
+	}
+	switch first := legs[0].Status; first {
+	case models.TransferApproved, models.TransferAwaitingDriver, models.TransferPickedUp:
+		return first
+	}
+	return models.TransferInTransit
+}
+
+// RouteTransfer sends a transfer that has not been picked up yet through
+// the given hubs, in order, replacing any earlier route; no hubs makes it
+// direct again. The driver already dispatched to the donor keeps the first
+// leg and every later leg is dispatched from its hub.
+func RouteTransfer(tx *gorm.DB, transferID string, via []string, actorID string) (*models.Transfer, error) {
+	var transfer models.Transfer
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&transfer, "id = ?", transferID).Error; err != nil {
+		return nil, fmt.Errorf("%w: transfer %s", ErrNotFound, transferID)
+	}
+	if transfer.ConsignmentID != nil {
+		return nil, fmt.Errorf("%w: consignments travel direct", ErrInvalidInput)
+	}
+	if transfer.Status != models.TransferApproved && transfer.Status != models.TransferAwaitingDriver {
+		return nil, fmt.Errorf("%w: route cannot change while transfer is %s", ErrInvalidTransition, transfer.Status)
+	}
+	if _, err := lockLegs(tx, transfer.ID); err != nil {
+		return nil, err
+	}
+	hubs, err := routeHubs(tx, &transfer, via)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Where("transfer_id = ?", transfer.ID).Delete(&models.TransferLeg{}).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	in := TransitionInput{ActorID: actorID, Note: "Route: direct"}
+	if len(hubs) == 0 {
+		if eta := EstimateArrivalAtApproval(tx, &transfer, now); eta != nil {
+			if err := tx.Model(&transfer).Update("estimated_arrival_time", eta).Error; err != nil {
+				return nil, err
+			}
+		}
+		if err := recordTransferEvent(tx, transfer.ID, transfer.Status, transfer.Status, in); err != nil {
+			return nil, err
+		}
+		return LoadRoutedTransfer(tx, transfer.ID)
+	}
+
+	stops := []string{transfer.FromFacilityID}
+	names := []string{}
+	for _, h := range hubs {
+		stops = append(stops, h.ID)
+		names = append(names, h.Name)
+	}
+	stops = append(stops, transfer.ToFacilityID)
+
+	legs := make([]models.TransferLeg, 0, len(stops)-1)
+	for i := 0; i < len(stops)-1; i++ {
+		leg := models.TransferLeg{
+			ID:             uuid.New().String(),
+			TransferID:     transfer.ID,
+			Sequence:       i + 1,
+			FromFacilityID: stops[i],
+			ToFacilityID:   stops[i+1],
+			Status:         models.TransferAwaitingDriver,
+			VehicleType:    transfer.VehicleType,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		}
+		if i == 0 {
+			leg.Status = transfer.Status
+			leg.DriverID = transfer.DriverID
+			leg.VehicleID = transfer.VehicleID
+			leg.VehicleNumber = transfer.VehicleNumber
+		} else {
+			driver, err := SelectDriver(tx, leg.FromFacilityID, transfer.Quantity, transfer.ColdChain)
+			if err != nil {
+				return nil, fmt.Errorf("failed to dispatch driver: %w", err)
+			}
+			if driver != nil {
+				setLegDriver(&leg, driver)
+				leg.Status = models.TransferApproved
+			}
+		}
+		legs = append(legs, leg)
+	}
+	estimateLegs(tx, legs, now, nil)
+	if err := tx.Create(&legs).Error; err != nil {
+		return nil, fmt.Errorf("failed to create legs: %w", err)
+	}
+
+	in.Note = "Route: via " + strings.Join(names, ", ")
+	if err := recordTransferEvent(tx, transfer.ID, transfer.Status, transfer.Status, in); err != nil {
+		return nil, err
+	}
+	if err := syncRouted(tx, &transfer, legs, in); err != nil {
+		return nil, err
+	}
+	return LoadRoutedTransfer(tx, transfer.ID)
+}
+
+// routeHubs checks the hubs of a route: known hub facilities, each visited
+// once and neither end of the transfer.
+func routeHubs(tx *gorm.DB, t *models.Transfer, via []string) ([]models.Facility, error) {
+	hubs := make([]models.Facility, 0, len(via))
+	seen := map[string]bool{t.FromFacilityID: true, t.ToFacilityID: true}
+	for _, id := range via {
+		if seen[id] {
+			return nil, fmt.Errorf("%w: facility %s appears twice on the route", ErrInvalidInput, id)
+		}
+		seen[id] = true
+		var hub models.Facility
+		if err := tx.First(&hub, "id = ?", id).Error; err != nil {
+			return nil, fmt.Errorf("%w: unknown hub %s", ErrInvalidInput, id)
+		}
+		if !isHub(hub) {
+			return nil, fmt.Errorf("%w: %s is a %s, not a warehouse or medical college", ErrInvalidInput, hub.Name, hub.FacilityType)
+		}
+		hubs = append(hubs, hub)
+	}
+	return hubs, nil
+}
+
+func isHub(f models.Facility) bool {
+	for _, t := range HubFacilityTypes {
+		if strings.EqualFold(f.FacilityType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// transitionRouted applies a lifecycle step to a hub-routed transfer.
+// Cancelling or failing ends every open leg with the transfer; any other
+// step moves the current leg, and the transfer follows.
+func transitionRouted(tx *gorm.DB, transfer *models.Transfer, legs []models.TransferLeg, to string, in TransitionInput) error {
+	if to == models.TransferCancelled || to == models.TransferFailed {
+		if err := transitionLine(tx, transfer, to, in); err != nil {
+			return err
+		}
+		return tx.Model(&models.TransferLeg{}).
+			Where("transfer_id = ? AND status <> ?", transfer.ID, models.TransferDelivered).
+			Updates(map[string]interface{}{"status": to, "updated_at": transfer.UpdatedAt}).Error
+	}
+
+	leg := currentLeg(legs)
+	if leg == nil {
+		return fmt.Errorf("%w: every leg is already delivered", ErrInvalidTransition)
+	}
+	from := leg.Status
+	if !CanTransition(from, to) {
+		return fmt.Errorf("%w: leg %d: %s -> %s", ErrInvalidTransition, leg.Sequence, from, to)
+	}
+
+	now := time.Now()
+	leg.Status = to
+	leg.UpdatedAt = now
+	switch to {
+	case models.TransferPickedUp, models.TransferInTransit:
+		if leg.PickedUpAt == nil {
+			leg.PickedUpAt = &now
+			leg.EstimatedArrivalTime = nil // Re-estimated from the actual departure
+		}
+	case models.TransferDelivered:
+		leg.HandedOverAt = &now
+		leg.HandedOverBy = in.ActorID
+	}
+	if err := tx.Model(leg).Updates(map[string]interface{}{
+		"status":         leg.Status,
+		"updated_at":     leg.UpdatedAt,
+		"picked_up_at":   leg.PickedUpAt,
+		"handed_over_at": leg.HandedOverAt,
+		"handed_over_by": leg.HandedOverBy,
+	}).Error; err != nil {
+		return err
+	}
+	if err := recordStepEvent(tx, transfer.ID, &leg.Sequence, from, to, in); err != nil {
+		return err
+	}
+	return syncRouted(tx, transfer, legs, in)
+}
+
+// syncRouted moves the transfer to the status its legs imply and copies the
+// current leg's driver and vehicle, plus the end-to-end ETA, onto it so
+// dispatch, tracking and custody scans keep working on the transfer.
+func syncRouted(tx *gorm.DB, transfer *models.Transfer, legs []models.TransferLeg, in TransitionInput) error {
+	if status := routedStatus(legs); status != transfer.Status {
+		if err := transitionLine(tx, transfer, status, in); err != nil {
+			return err
+		}
+	}
+
+	updates := map[string]interface{}{}
+	if eta := estimateLegs(tx, legs, time.Now(), nil); eta != nil {
+		if err := saveLegETAs(tx, legs); err != nil {
+			return err
+		}
+		transfer.EstimatedArrivalTime = eta
+		updates["estimated_arrival_time"] = eta
+	}
+	if leg := currentLeg(legs); leg != nil {
+		transfer.DriverID, transfer.VehicleID = leg.DriverID, leg.VehicleID
+		transfer.VehicleType, transfer.VehicleNumber = leg.VehicleType, leg.VehicleNumber
+		updates["driver_id"] = leg.DriverID
+		updates["vehicle_id"] = leg.VehicleID
+		updates["vehicle_type"] = leg.VehicleType
+		updates["vehicle_number"] = leg.VehicleNumber
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	return tx.Model(transfer).Updates(updates).Error
+}
+
+// estimateLegs re-estimates every leg not yet handed over, each departing
+// when the one before is due, and returns the arrival of the last. pos is
+// the latest fix of the vehicle on the current leg, if known.
+func estimateLegs(tx *gorm.DB, legs []models.TransferLeg, now time.Time, pos *Coord) *time.Time {
+	departure := now
+	var last *time.Time
+	for i := range legs {
+		leg := &legs[i]
+		if leg.Status == models.TransferDelivered {
+			continue
+		}
+		hop := &models.Transfer{FromFacilityID: leg.FromFacilityID, ToFacilityID: leg.ToFacilityID, VehicleType: leg.VehicleType}
+		var eta *time.Time
+		switch {
+		case isActiveTransfer(leg.Status) && pos != nil:
+			eta = EstimateArrival(tx, hop, now, pos)
+		case isActiveTransfer(leg.Status) && leg.PickedUpAt != nil:
+			eta = leg.EstimatedArrivalTime
+			if eta == nil {
+				eta = EstimateArrival(tx, hop, *leg.PickedUpAt, nil)
+			}
+		default:
+			eta = EstimateArrivalAtApproval(tx, hop, departure)
+		}
+		pos = nil
+		if eta != nil {
+			leg.EstimatedArrivalTime = eta
+			last = eta
+			if eta.After(departure) {
+				departure = *eta
+			}
+		}
+	}
+	return last
+}
+
+func saveLegETAs(tx *gorm.DB, legs []models.TransferLeg) error {
+	for _, leg := range legs {
+		if leg.Status == models.TransferDelivered || leg.EstimatedArrivalTime == nil {
+			continue
+		}
+		if err := tx.Model(&models.TransferLeg{}).Where("id = ?", leg.ID).
+			Update("estimated_arrival_time", leg.EstimatedArrivalTime).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setLegDriver(leg *models.TransferLeg, d *DriverCandidate) {
+	leg.DriverID = &d.DriverID
+	leg.VehicleID = &d.VehicleID
+	leg.VehicleType = d.VehicleType
+	leg.VehicleNumber = d.Registration
+}
+
+// assignLegDriver is AssignDriver for a hub-routed transfer: it (re)assigns
+// the current leg, which may be waiting at a hub while the transfer is
+// already IN_TRANSIT.
+func assignLegDriver(tx *gorm.DB, transfer *models.Transfer, legs []models.TransferLeg, driverID, actorID string) (*models.Transfer, error) {
+	leg := currentLeg(legs)
+	if leg == nil || (leg.Status != models.TransferApproved && leg.Status != models.TransferAwaitingDriver) {
+		return nil, fmt.Errorf("%w: driver cannot be changed once the current leg is under way", ErrInvalidTransition)
+	}
+
+	var driver *DriverCandidate
+	var err error
+	if driverID != "" {
+		driver, err = driverWithVehicle(tx, leg.FromFacilityID, driverID, leg.DriverID, transfer.Quantity, transfer.ColdChain)
+	} else {
+		driver, err = SelectDriver(tx, leg.FromFacilityID, transfer.Quantity, transfer.ColdChain)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	target := models.TransferAwaitingDriver
+	note := fmt.Sprintf("Leg %d: no driver available", leg.Sequence)
+	if driver != nil {
+		target = models.TransferApproved
+		note = fmt.Sprintf("Leg %d: driver assigned: %s", leg.Sequence, driver.Email)
+		setLegDriver(leg, driver)
+	} else {
+		leg.DriverID, leg.VehicleID = nil, nil
+	}
+	if err := tx.Model(leg).Updates(map[string]interface{}{
+		"driver_id":      leg.DriverID,
+		"vehicle_id":     leg.VehicleID,
+		"vehicle_type":   leg.VehicleType,
+		"vehicle_number": leg.VehicleNumber,
+		"updated_at":     time.Now(),
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	in := TransitionInput{ActorID: actorID, Note: note}
+	if target != leg.Status {
+		err = transitionRouted(tx, transfer, legs, target, in)
+	} else if err = recordStepEvent(tx, transfer.ID, &leg.Sequence, leg.Status, target, in); err == nil {
+		err = syncRouted(tx, transfer, legs, in)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return transfer, nil
+}
+
+// custody is who holds a transfer's next handover: the current leg for a
+// routed transfer, the transfer itself otherwise.
+type custody struct {
+	Status     string
+	DriverID   *string
+	ReceiverID string
+	Leg        int // 0 for direct transfers
+	Legs       int
+}
+
+func custodyOf(tx *gorm.DB, t *models.Transfer) custody {
+	c := custody{Status: t.Status, DriverID: t.DriverID, ReceiverID: t.ToFacilityID}
+	legs := routeLegs(tx, t.ID)
+	if leg := currentLeg(legs); leg != nil {
+		c = custody{Status: leg.Status, DriverID: leg.DriverID, ReceiverID: leg.ToFacilityID, Leg: leg.Sequence, Legs: len(legs)}
+	}
+	return c
+}
+
+// LoadRoutedTransfer returns a transfer with its legs in route order.
+func LoadRoutedTransfer(tx *gorm.DB, id string) (*models.Transfer, error) {
+	var t models.Transfer
+	if err := tx.Preload("Legs", func(db *gorm.DB) *gorm.DB { return db.Order("sequence") }).
+		Preload("Legs.FromFacility").Preload("Legs.ToFacility").
+		First(&t, "id = ?", id).Error; err != nil {
+		return nil, fmt.Errorf("%w: transfer %s", ErrNotFound, id)
+	}
+	return &t, nil
+}
+
+// PickupFacilityID is where the next driver collects a transfer: its
+// current leg's origin, or the donor for a direct transfer.
+func PickupFacilityID(tx *gorm.DB, t *models.Transfer) string {
+	if leg := currentLeg(routeLegs(tx, t.ID)); leg != nil {
+		return leg.FromFacilityID
+	}
+	return t.FromFacilityID
+}
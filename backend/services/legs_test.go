@@ -0,0 +1,77 @@
+package services
+
+import (
+	"backend/models"
+	"testing"
+)
+
+func legsIn(statuses ...string) []models.TransferLeg {
+	legs := make([]models.TransferLeg, len(statuses))
+	for i, s := range statuses {
+		legs[i] = models.TransferLeg{Sequence: i + 1, Status: s}
+	}
+	return legs
+}
+
+func TestRoutedStatus(t *testing.T) {
+	const (
+		waiting   = models.TransferAwaitingDriver
+		approved  = models.TransferApproved
+		picked    = models.TransferPickedUp
+		moving    = models.TransferInTransit
+		delivered = models.TransferDelivered
+	)
+	cases := []struct {
+		name string
+		legs []models.TransferLeg
+		want string
+	}{
+		{"waits with the first leg for a driver", legsIn(waiting, waiting), waiting},
+		{"approved while the first driver heads out", legsIn(approved, approved), approved},
+		{"picked up while the first driver holds it", legsIn(picked, approved), picked},
+		{"in transit on the first leg", legsIn(moving, approved), moving},
+		{"in transit at the hub", legsIn(delivered, approved), moving},
+		{"in transit while the next leg waits for a driver", legsIn(delivered, waiting), moving},
+		{"in transit on the last leg", legsIn(delivered, moving), moving},
+		{"in transit at a middle hub", legsIn(delivered, delivered, picked), moving},
+		{"delivered after the last handover", legsIn(delivered, delivered), delivered},
+		{"a single leg is delivered with it", legsIn(delivered), delivered},
+	}
+	for _, c := range cases {
+		if got := routedStatus(c.legs); got != c.want {
+			t.Errorf("%s: routedStatus = %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCurrentLeg(t *testing.T) {
+	cases := []struct {
+		name string
+		legs []models.TransferLeg
+		want int // Sequence; 0 for none
+	}{
+		{"first leg before pickup", legsIn(models.TransferApproved, models.TransferAwaitingDriver), 1},
+		{"first leg on the road", legsIn(models.TransferInTransit, models.TransferApproved), 1},
+		{"next leg after a handover", legsIn(models.TransferDelivered, models.TransferApproved), 2},
+		{"last leg", legsIn(models.TransferDelivered, models.TransferDelivered, models.TransferPickedUp), 3},
+		{"none once delivered", legsIn(models.TransferDelivered, models.TransferDelivered), 0},
+		{"none for a direct transfer", nil, 0},
+	}
+	for _, c := range cases {
+		got := 0
+		if leg := currentLeg(c.legs); leg != nil {
+			got = leg.Sequence
+		}
+		if got != c.want {
+			t.Errorf("%s: currentLeg = %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCurrentLegPointsIntoSlice(t *testing.T) {
+	legs := legsIn(models.TransferDelivered, models.TransferApproved)
+	currentLeg(legs).Status = models.TransferPickedUp
+	if legs[1].Status != models.TransferPickedUp {
+		t.Errorf("currentLeg returned a copy; transitions would not reach the slice")
+	}
+}
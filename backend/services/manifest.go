@@ -0,0 +1,266 @@
+package services
+
+import (
+	"backend/models"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Custody steps a QR manifest can authorise.
+const (
+	ScanStepPickup   = "PICKUP"   // Donor hands over to the driver
+	ScanStepDelivery = "DELIVERY" // Driver hands over to the receiving PHC
+)
+
+// ManifestBatch is one batch line printed on a QR manifest.
+type ManifestBatch struct {
+	BatchID    string `json:"batch_id"`
+	Quantity   int    `json:"qty"`
+	ExpiryDate string `json:"expiry"`
+}
+
+// ManifestPayload is the signed content of a transfer QR code.
+type ManifestPayload struct {
+	TransferID string          `json:"tid"`
+	Leg        int             `json:"leg,omitempty"` // Leg of a hub-routed transfer
+	Step       string          `json:"step"`
+	ItemID     string          `json:"item"`
+	Quantity   int             `json:"qty"`
+	Batches    []ManifestBatch `json:"batches"`
+	IssuedAt   int64           `json:"iat"`
+}
+
+// ScanInput is the scanning user as taken from the JWT. IssueManifest
+// takes the same: the user asking for a code. A delivery scan that hands
+// the stock to the recipient also carries what the staff counted, as
+// ConfirmDelivery takes it.
+type ScanInput struct {
+	UserID     string
+	FacilityID string
+	Received   []ReceivedLine
+	Condition  string
+	Note       string
+}
+
+// DefaultManifestTTL is how long a QR code stays valid unless
+// QR_MANIFEST_TTL_MINUTES says otherwise.
+const DefaultManifestTTL = 12 * time.Hour
+
+// manifestSecret is kept apart from JWT_SECRET so a leaked QR key cannot
+// mint login tokens, or the other way round.
+func manifestSecret() ([]byte, error) {
+	secret := os.Getenv("QR_SIGNING_SECRET")
+	if secret == "" {
+		return nil, errors.New("QR_SIGNING_SECRET is not configured")
+	}
+	return []byte(secret), nil
+}
+
+func manifestTTL() time.Duration {
+	if m, err := strconv.Atoi(os.Getenv("QR_MANIFEST_TTL_MINUTES")); err == nil && m > 0 {
+		return time.Duration(m) * time.Minute
+	}
+	return DefaultManifestTTL
+}
+
+// SignManifest encodes a payload as base64url(json) "." base64url(hmac-sha256).
+func SignManifest(p ManifestPayload) (string, error) {
+	secret, err := manifestSecret()
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(body) + "." + enc.EncodeToString(mac.Sum(nil)), nil
+}
+
+// VerifyManifest checks the signature and decodes the payload. Codes older
+// than the manifest lifetime are refused, so a photographed code cannot be
+// replayed later.
+func VerifyManifest(code string) (*ManifestPayload, error) {
+	secret, err := manifestSecret()
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(strings.TrimSpace(code), ".")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("%w: malformed QR code", ErrForbidden)
+	}
+	enc := base64.RawURLEncoding
+	body, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed QR code", ErrForbidden)
+	}
+	sig, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed QR code", ErrForbidden)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, fmt.Errorf("%w: QR signature is invalid", ErrForbidden)
+	}
+
+	var p ManifestPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("%w: malformed QR payload", ErrForbidden)
+	}
+	if time.Since(time.Unix(p.IssuedAt, 0)) > manifestTTL() {
+		return nil, fmt.Errorf("%w: QR code has expired, issue a new one", ErrForbidden)
+	}
+	return &p, nil
+}
+
+// IssueManifest builds and signs the QR for the next custody step of a
+// transfer. Only the driver holding it and staff of the facility handing
+// it over (the donor, or the hub on the current leg) get a code.
+func IssueManifest(tx *gorm.DB, transferID, step string, in ScanInput) (string, *ManifestPayload, error) {
+	var transfer models.Transfer
+	if err := tx.First(&transfer, "id = ?", transferID).Error; err != nil {
+		return "", nil, fmt.Errorf("%w: transfer %s", ErrNotFound, transferID)
+	}
+	hold := custodyOf(tx, &transfer)
+	isDriver := hold.DriverID != nil && *hold.DriverID == in.UserID
+	atDonor := in.FacilityID != "" && (in.FacilityID == transfer.FromFacilityID || in.FacilityID == PickupFacilityID(tx, &transfer))
+	if !isDriver && !atDonor {
+		return "", nil, fmt.Errorf("%w: only the assigned driver or the donor facility can issue this QR code", ErrForbidden)
+	}
+	if _, err := stepTarget(step, hold.Status); err != nil {
+		return "", nil, err
+	}
+
+	payload := manifestFor(&transfer, step, hold.Leg)
+	payload.IssuedAt = time.Now().Unix()
+	code, err := SignManifest(payload)
+	if err != nil {
+		return "", nil, err
+	}
+	return code, &payload, nil
+}
+
+// ScanManifest verifies a scanned QR and moves the transfer to the next
+// custody state. Pickup must be scanned by the assigned driver, delivery
+// by staff of the receiving facility. On a hub-routed transfer both refer
+// to the current leg, so a hub receives and then hands over again. The
+// final delivery is reconciled by ConfirmDelivery with the counted lines,
+// so the scan credits, writes off and logs exactly as a manual
+// confirmation would; the report is nil for every other step.
+func ScanManifest(tx *gorm.DB, code string, in ScanInput) (*models.Transfer, *DeliveryReport, error) {
+	payload, err := VerifyManifest(code)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var transfer models.Transfer
+	if err := tx.First(&transfer, "id = ?", payload.TransferID).Error; err != nil {
+		return nil, nil, fmt.Errorf("%w: transfer %s", ErrNotFound, payload.TransferID)
+	}
+
+	// The manifest must still describe the transfer as it stands
+	hold := custodyOf(tx, &transfer)
+	current := manifestFor(&transfer, payload.Step, hold.Leg)
+	current.IssuedAt = payload.IssuedAt
+	if !manifestsEqual(&current, payload) {
+		return nil, nil, fmt.Errorf("%w: QR manifest does not match the transfer", ErrInvalidInput)
+	}
+
+	switch payload.Step {
+	case ScanStepPickup:
+		if hold.DriverID == nil || *hold.DriverID != in.UserID {
+			return nil, nil, fmt.Errorf("%w: only the assigned driver can collect this transfer", ErrForbidden)
+		}
+	case ScanStepDelivery:
+		if in.FacilityID == "" || in.FacilityID != hold.ReceiverID {
+			return nil, nil, fmt.Errorf("%w: only the receiving facility can accept this transfer", ErrForbidden)
+		}
+	}
+
+	to, err := stepTarget(payload.Step, hold.Status)
+	if err != nil {
+		return nil, nil, err
+	}
+	note := "QR scan: " + payload.Step
+	if hold.Leg > 0 {
+		note = fmt.Sprintf("QR scan: %s (leg %d of %d)", payload.Step, hold.Leg, hold.Legs)
+	}
+
+	// Handing over to the recipient closes the transfer
+	if to == models.TransferDelivered && hold.Leg == hold.Legs {
+		if in.Note != "" {
+			note = in.Note
+		}
+		report, err := ConfirmDelivery(tx, transfer.ID, DeliveryConfirmation{
+			ActorID:    in.UserID,
+			FacilityID: in.FacilityID,
+			Lines:      in.Received,
+			Condition:  in.Condition,
+			Note:       note,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := tx.First(&transfer, "id = ?", transfer.ID).Error; err != nil {
+			return nil, nil, err
+		}
+		return &transfer, report, nil
+	}
+
+	moved, err := TransitionTransfer(tx, transfer.ID, to, TransitionInput{
+		ActorID: in.UserID,
+		Note:    note,
+	})
+	return moved, nil, err
+}
+
+// stepTarget is the status a custody step moves a transfer into.
+func stepTarget(step, status string) (string, error) {
+	var to string
+	switch step {
+	case ScanStepPickup:
+		to = models.TransferInTransit
+	case ScanStepDelivery:
+		to = models.TransferDelivered
+	default:
+		return "", fmt.Errorf("%w: unknown custody step %q", ErrInvalidInput, step)
+	}
+	if !CanTransition(status, to) {
+		return "", fmt.Errorf("%w: %s scan not allowed while transfer is %s", ErrInvalidTransition, step, status)
+	}
+	return to, nil
+}
+
+func manifestFor(t *models.Transfer, step string, leg int) ManifestPayload {
+	batches := make([]ManifestBatch, 0, len(t.Batches))
+	for _, b := range t.Batches {
+		batches = append(batches, ManifestBatch{BatchID: b.BatchID, Quantity: b.Quantity, ExpiryDate: b.ExpiryDate})
+	}
+	return ManifestPayload{
+		TransferID: t.ID,
+		Leg:        leg,
+		Step:       step,
+		ItemID:     t.ItemID,
+		Quantity:   t.Quantity,
+		Batches:    batches,
+	}
+}
+
+func manifestsEqual(a, b *ManifestPayload) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
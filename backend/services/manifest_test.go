@@ -0,0 +1,118 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testManifest() ManifestPayload {
+	return ManifestPayload{
+		TransferID: "7d1f6c0e-1111-4c2b-9a55-000000000001",
+		Step:       ScanStepPickup,
+		ItemID:     "ITEM-001",
+		Quantity:   40,
+		Batches:    []ManifestBatch{{BatchID: "B1", Quantity: 40, ExpiryDate: "2099-01-01"}},
+		IssuedAt:   time.Now().Unix(),
+	}
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	t.Setenv("QR_SIGNING_SECRET", "test-secret")
+	want := testManifest()
+	code, err := SignManifest(want)
+	if err != nil {
+		t.Fatalf("SignManifest: %v", err)
+	}
+	got, err := VerifyManifest(code)
+	if err != nil {
+		t.Fatalf("VerifyManifest: %v", err)
+	}
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("VerifyManifest = %+v, want %+v", *got, want)
+	}
+	if _, err := VerifyManifest("  " + code + "\n"); err != nil {
+		t.Errorf("VerifyManifest with surrounding whitespace: %v", err)
+	}
+}
+
+func TestVerifyManifestRejects(t *testing.T) {
+	t.Setenv("QR_SIGNING_SECRET", "test-secret")
+	t.Setenv("QR_MANIFEST_TTL_MINUTES", "60")
+	code, err := SignManifest(testManifest())
+	if err != nil {
+		t.Fatalf("SignManifest: %v", err)
+	}
+	body, sig, _ := strings.Cut(code, ".")
+
+	// Same signature over a larger quantity
+	tampered := testManifest()
+	tampered.Quantity = 400
+	raw, _ := json.Marshal(tampered)
+	forged := base64.RawURLEncoding.EncodeToString(raw) + "." + sig
+
+	stale := testManifest()
+	stale.IssuedAt = time.Now().Add(-2 * time.Hour).Unix()
+	old, err := SignManifest(stale)
+	if err != nil {
+		t.Fatalf("SignManifest: %v", err)
+	}
+
+	cases := map[string]string{
+		"no signature":      body,
+		"extra part":        code + ".x",
+		"bad base64":        "!!!." + sig,
+		"tampered payload":  forged,
+		"truncated sig":     body + "." + sig[:len(sig)-4],
+		"expired":           old,
+		"empty":             "",
+		"signature as body": sig + "." + sig,
+	}
+	for name, c := range cases {
+		if _, err := VerifyManifest(c); !errors.Is(err, ErrForbidden) {
+			t.Errorf("%s: got %v, want ErrForbidden", name, err)
+		}
+	}
+}
+
+func TestVerifyManifestOtherSecret(t *testing.T) {
+	t.Setenv("QR_SIGNING_SECRET", "first")
+	code, err := SignManifest(testManifest())
+	if err != nil {
+		t.Fatalf("SignManifest: %v", err)
+	}
+	t.Setenv("QR_SIGNING_SECRET", "second")
+	if _, err := VerifyManifest(code); !errors.Is(err, ErrForbidden) {
+		t.Errorf("code signed with another secret: got %v, want ErrForbidden", err)
+	}
+}
+
+func TestManifestNeedsSecret(t *testing.T) {
+	t.Setenv("QR_SIGNING_SECRET", "")
+	if _, err := SignManifest(testManifest()); err == nil {
+		t.Error("SignManifest without QR_SIGNING_SECRET succeeded")
+	}
+	if _, err := VerifyManifest("a.b"); err == nil {
+		t.Error("VerifyManifest without QR_SIGNING_SECRET succeeded")
+	}
+}
+
+func TestManifestTTL(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":    DefaultManifestTTL,
+		"abc": DefaultManifestTTL,
+		"0":   DefaultManifestTTL,
+		"-5":  DefaultManifestTTL,
+		"90":  90 * time.Minute,
+	}
+	for env, want := range cases {
+		t.Setenv("QR_MANIFEST_TTL_MINUTES", env)
+		if got := manifestTTL(); got != want {
+			t.Errorf("QR_MANIFEST_TTL_MINUTES=%q: manifestTTL = %v, want %v", env, got, want)
+		}
+	}
+}
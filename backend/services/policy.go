@@ -0,0 +1,208 @@
+package services
+
+import (
+	"backend/models"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// PolicyActorPrefix marks approvals made by a policy rather than a person;
+// the approver is recorded as "policy:<rule>".
+const PolicyActorPrefix = "policy:"
+
+// ApprovalPolicy is one auto-approval rule. Rules are SystemSetting keys
+// of the form "auto_approve.<rule>.<field>", per district with GLOBAL as
+// fallback:
+//
+//	max_value_inr     required, card value (quantity x unit cost) ceiling
+//	min_confidence    required, minimum ConfidenceScore (0-100)
+//	classes           optional, comma-separated therapeutic classes
+//	min_donor_buffer  optional, donor stock left after the transfer as a
+//	                  multiple of its safety stock (default 1.0)
+//
+// "auto_approve_enabled" = "false" switches auto-approval off for a district.
+type ApprovalPolicy struct {
+	Name           string   `json:"name"`
+	MaxValueINR    float64  `json:"max_value_inr"`
+	MinConfidence  float64  `json:"min_confidence"`
+	Classes        []string `json:"classes"`
+	MinDonorBuffer float64  `json:"min_donor_buffer"`
+}
+
+// PolicyFacts are the properties of a card a policy is judged against.
+type PolicyFacts struct {
+	ValueINR       float64
+	Confidence     float64
+	Class          string
+	DonorRemaining int // Donor's available stock after the transfer
+	DonorSafety    int
+}
+
+// Matches reports whether the facts fall inside the rule.
+func (p ApprovalPolicy) Matches(f PolicyFacts) bool {
+	if f.ValueINR > p.MaxValueINR || f.Confidence < p.MinConfidence {
+		return false
+	}
+	if len(p.Classes) > 0 {
+		allowed := false
+		for _, c := range p.Classes {
+			if strings.EqualFold(c, f.Class) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return float64(f.DonorRemaining) >= p.MinDonorBuffer*float64(f.DonorSafety)
+}
+
+// LoadPolicies reads the district's auto-approval rules, sorted by name.
+// Rules missing a required field are ignored.
+func LoadPolicies(tx *gorm.DB, district string) []ApprovalPolicy {
+	if strings.EqualFold(DistrictSetting(tx, district, "auto_approve_enabled", "true"), "false") {
+		return nil
+	}
+
+	var settings []models.SystemSetting
+	tx.Where("setting_key LIKE ? AND district IN ?", "auto_approve.%", []string{district, "GLOBAL"}).Find(&settings)
+
+	// District values override GLOBAL ones key by key
+	values := map[string]map[string]string{}
+	fromDistrict := map[string]bool{}
+	for _, s := range settings {
+		parts := strings.SplitN(strings.TrimPrefix(s.SettingKey, "auto_approve."), ".", 2)
+		if len(parts) != 2 || (fromDistrict[s.SettingKey] && s.District != district) {
+			continue
+		}
+		if values[parts[0]] == nil {
+			values[parts[0]] = map[string]string{}
+		}
+		values[parts[0]][parts[1]] = strings.TrimSpace(s.SettingValue)
+		fromDistrict[s.SettingKey] = s.District == district
+	}
+
+	var policies []ApprovalPolicy
+	for name, v := range values {
+		maxValue, err1 := strconv.ParseFloat(v["max_value_inr"], 64)
+		minConfidence, err2 := strconv.ParseFloat(v["min_confidence"], 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		p := ApprovalPolicy{Name: name, MaxValueINR: maxValue, MinConfidence: minConfidence, MinDonorBuffer: 1}
+		if b, err := strconv.ParseFloat(v["min_donor_buffer"], 64); err == nil {
+			p.MinDonorBuffer = b
+		}
+		for _, c := range strings.Split(v["classes"], ",") {
+			if c = strings.TrimSpace(c); c != "" {
+				p.Classes = append(p.Classes, c)
+			}
+		}
+		policies = append(policies, p)
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Name < policies[j].Name })
+	return policies
+}
+
+// MatchPolicy returns the first of the donor district's rules the card
+// satisfies, or nil. A multi-item card must satisfy the rule on every
+// line, with the value ceiling applied to the card as a whole.
+func MatchPolicy(tx *gorm.DB, card *models.SolutionCard, spec *TransferSpec) (*ApprovalPolicy, error) {
+	policies := LoadPolicies(tx, facilityDistrict(tx, spec.FromFacilityID))
+	if len(policies) == 0 {
+		return nil, nil
+	}
+
+	lines := make([]PolicyFacts, 0, len(spec.Lines))
+	total := 0.0
+	for _, l := range spec.Lines {
+		var item models.Item
+		if err := tx.First(&item, "id = ?", l.ItemID).Error; err != nil {
+			return nil, fmt.Errorf("%w: item %s", ErrNotFound, l.ItemID)
+		}
+		var donor models.Inventory
+		if err := tx.Where("facility_id = ? AND item_id = ?", spec.FromFacilityID, l.ItemID).First(&donor).Error; err != nil {
+			return nil, fmt.Errorf("%w: donor does not stock item %s", ErrInsufficientStock, l.ItemID)
+		}
+		total += float64(l.Quantity) * item.UnitCost
+		lines = append(lines, PolicyFacts{
+			Confidence:     card.ConfidenceScore,
+			Class:          item.TherapeuticClass,
+			DonorRemaining: donor.Available() - l.Quantity,
+			DonorSafety:    donor.SafetyStockLevel,
+		})
+	}
+
+	for i := range policies {
+		matched := true
+		for _, facts := range lines {
+			facts.ValueINR = total
+			if !policies[i].Matches(facts) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return &policies[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// AutoApprovalSummary lists what one auto-approval pass approved.
+type AutoApprovalSummary struct {
+	Evaluated int               `json:"evaluated"`
+	Approved  []*ApprovalResult `json:"approved"`
+	Failed    int               `json:"failed"`
+}
+
+// AutoApprovePending approves pending AI cards that match a policy, each
+// in its own transaction through the normal approval path. Cards that
+// match nothing, or fail approval, stay pending for a reviewer. db must
+// not be a transaction itself.
+func AutoApprovePending(db *gorm.DB) (*AutoApprovalSummary, error) {
+	var ids []string
+	if err := db.Model(&models.SolutionCard{}).
+		Where("status = ? AND source = ?", models.CardPending, models.CardSourceAI).
+		Order("priority_score DESC, created_at ASC").Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+
+	summary := &AutoApprovalSummary{Approved: []*ApprovalResult{}}
+	for _, id := range ids {
+		var result *ApprovalResult
+		err := db.Transaction(func(tx *gorm.DB) error {
+			card, err := lockPendingCard(tx, id)
+			if err != nil {
+				return err
+			}
+			if PendingStep(tx, card) != StepDHO {
+				return nil // Donor has not consented yet
+			}
+			spec, err := ResolveTransferSpec(tx, card)
+			if err != nil {
+				return err
+			}
+			policy, err := MatchPolicy(tx, card, spec)
+			if err != nil || policy == nil {
+				return err
+			}
+			result, err = ApproveCard(tx, id, ApprovalInput{ActorID: PolicyActorPrefix + policy.Name, ByPolicy: true})
+			return err
+		})
+		summary.Evaluated++
+		switch {
+		case err != nil && !errors.Is(err, ErrAlreadyProcessed):
+			summary.Failed++
+		case result != nil:
+			summary.Approved = append(summary.Approved, result)
+		}
+	}
+	return summary, nil
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"backend/models"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ReplenishmentSource marks cards and stock requests raised by the sweep.
+const ReplenishmentSource = "REPLENISHMENT"
+
+// ReplenishmentSummary counts what one sweep of a district did.
+type ReplenishmentSummary struct {
+	District      string   `json:"district"`
+	AtRisk        int      `json:"at_risk"`
+	CardsCreated  []string `json:"cards_created"`
+	StockRequests []string `json:"stock_requests"`
+	Covered       int      `json:"covered"` // Already has a pending card, inbound transfer or open request
+	Failed        int      `json:"failed"`
+}
+
+// Replenish is the Go port of the ML daily cycle. Stock whose available
+// level after "replenishment_forecast_days" (default 3) of consumption is
+// below its safety stock gets topped up to safety stock plus
+// "replenishment_target_days" (default 7) of consumption: from the best
+// donor as a RULES card, or as a stock request when no facility can cover
+// it. Each shortfall is handled in its own transaction; db must not be a
+// transaction itself.
+func Replenish(db *gorm.DB, district string) (*ReplenishmentSummary, error) {
+	forecastDays := DistrictSettingInt(db, district, "replenishment_forecast_days", 3)
+	targetDays := DistrictSettingInt(db, district, "replenishment_target_days", 7)
+
+	// Reserved and quarantined units are not there to use, so the
+	// projection runs on Available(), which SQL cannot see into
+	var stock []models.Inventory
+	if err := db.Joins("JOIN facilities f ON f.id = inventories.facility_id").
+		Where("f.district = ?", district).
+		Find(&stock).Error; err != nil {
+		return nil, err
+	}
+	var atRisk []models.Inventory
+	for _, inv := range stock {
+		if float64(inv.Available())-inv.ConsumptionRate*float64(forecastDays) < float64(inv.SafetyStockLevel) {
+			atRisk = append(atRisk, inv)
+		}
+	}
+
+	summary := &ReplenishmentSummary{District: district, AtRisk: len(atRisk), CardsCreated: []string{}, StockRequests: []string{}}
+	if len(atRisk) == 0 {
+		return summary, nil
+	}
+	covered, err := coveredShortfalls(db)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, inv := range atRisk {
+		if covered[inv.FacilityID+":"+inv.ItemID] {
+			summary.Covered++
+			continue
+		}
+		deficit := int(math.Ceil(float64(inv.SafetyStockLevel) + inv.ConsumptionRate*float64(targetDays) - float64(inv.Available())))
+		if deficit <= 0 {
+			continue
+		}
+
+		var cardID, requestID string
+		err := db.Transaction(func(tx *gorm.DB) error {
+			rec, err := FindDonors(tx, DonorQuery{FacilityID: inv.FacilityID, ItemID: inv.ItemID, Quantity: deficit, Limit: 1})
+			if err != nil {
+				return err
+			}
+			if len(rec.Candidates) > 0 {
+				card, err := CreateRulesCard(tx, rec, "")
+				if err != nil {
+					return err
+				}
+				cardID = card.ID
+				return nil
+			}
+			request := models.StockRequest{
+				ID:         uuid.New().String(),
+				FacilityID: inv.FacilityID,
+				ItemID:     inv.ItemID,
+				Quantity:   deficit,
+				Status:     models.StockRequestOpen,
+				Source:     ReplenishmentSource,
+				Reason:     fmt.Sprintf("Projected below safety stock within %d days; no facility has a true surplus of %d", forecastDays, deficit),
+				CreatedAt:  time.Now(),
+			}
+			requestID = request.ID
+			return tx.Create(&request).Error
+		})
+		switch {
+		case err != nil:
+			summary.Failed++
+		case cardID != "":
+			summary.CardsCreated = append(summary.CardsCreated, cardID)
+		case requestID != "":
+			summary.StockRequests = append(summary.StockRequests, requestID)
+		}
+	}
+	return summary, nil
+}
+
+// coveredShortfalls returns "facility:item" keys that already have help on
+// the way: a pending card, an active inbound transfer or an open stock
+// request. Rerunning the sweep therefore does not pile up duplicates.
+func coveredShortfalls(db *gorm.DB) (map[string]bool, error) {
+	covered := map[string]bool{}
+
+	var cards []models.SolutionCard
+	if err := db.Where("status = ?", models.CardPending).Find(&cards).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range NormalizeCardPayloads(db, cards) {
+		if p.DestinationFacilityID == "" {
+			continue
+		}
+		for _, l := range p.AllLines() {
+			covered[p.DestinationFacilityID+":"+l.ItemID] = true
+		}
+	}
+
+	type pair struct {
+		FacilityID string
+		ItemID     string
+	}
+	var inbound []pair
+	if err := db.Model(&models.Transfer{}).Select("to_facility_id AS facility_id, item_id").
+		Where("status IN ?", []string{models.TransferPending, models.TransferAwaitingDriver, models.TransferApproved,
+			models.TransferPickedUp, models.TransferInTransit}).
+		Scan(&inbound).Error; err != nil {
+		return nil, err
+	}
+	var requested []pair
+	if err := db.Model(&models.StockRequest{}).Select("facility_id, item_id").
+		Where("status = ?", models.StockRequestOpen).Scan(&requested).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range append(inbound, requested...) {
+		covered[p.FacilityID+":"+p.ItemID] = true
+	}
+	return covered, nil
+}
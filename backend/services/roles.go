@@ -0,0 +1,11 @@
+package services
+
+// User roles the services check against.
+const (
+	// RoleDHO is the District Health Officer, who oversees the district.
+	RoleDHO = "DHO"
+	// RoleAdmin administers the whole deployment rather than one district.
+	RoleAdmin = "ADMIN"
+	// RoleDriver drives transfers; drivers see only their own.
+	RoleDriver = "DRIVER"
+)
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"backend/models"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// DistrictSetting reads a SystemSetting, preferring the district's own
+// value over the GLOBAL one (same precedence as the admin panel).
+func DistrictSetting(tx *gorm.DB, district, key, fallback string) string {
+	var settings []models.SystemSetting
+	tx.Where("setting_key = ? AND district IN ?", key, []string{district, "GLOBAL"}).Find(&settings)
+
+	value := fallback
+	for _, s := range settings {
+		if s.District == district {
+			return s.SettingValue
+		}
+		value = s.SettingValue
+	}
+	return value
+}
+
+// DistrictSettingInt is DistrictSetting parsed as an int.
+func DistrictSettingInt(tx *gorm.DB, district, key string, fallback int) int {
+	if n, err := strconv.Atoi(DistrictSetting(tx, district, key, "")); err == nil {
+		return n
+	}
+	return fallback
+}
+
+// DistrictSettingFloat is DistrictSetting parsed as a float.
+func DistrictSettingFloat(tx *gorm.DB, district, key string, fallback float64) float64 {
+	if f, err := strconv.ParseFloat(DistrictSetting(tx, district, key, ""), 64); err == nil {
+		return f
+	}
+	return fallback
+}
+
+// facilityDistrict is the district a facility belongs to ("" if unknown).
+func facilityDistrict(tx *gorm.DB, facilityID string) string {
+	var f models.Facility
+	tx.Select("district").First(&f, "id = ?", facilityID)
+	return f.District
+}
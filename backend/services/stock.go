@@ -0,0 +1,229 @@
+package services
+
+import (
+	"backend/models"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Stock moves in three steps so recipients never see stock that is still
+// at the donor or in a van:
+//   approval -> ReserveStock   (donor reserved_quantity += qty, FEFO batches picked)
+//   pickup   -> DeductReserved (donor quantity and reserved_quantity -= qty, batches leave)
+//   delivery -> CreditStock    (recipient quantity += qty, batches merged in)
+// Cancelling undoes whichever step was reached: a reservation is released,
+// stock already picked up goes back to the donor with its batches.
+
+// lockInventory loads a donor/recipient inventory row FOR UPDATE.
+func lockInventory(tx *gorm.DB, facilityID, itemID string) (*models.Inventory, error) {
+	var inv models.Inventory
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("facility_id = ? AND item_id = ?", facilityID, itemID).
+		First(&inv).Error; err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// ReserveStock promises qty units of the donor's unreserved stock to a
+// transfer. The locked row must keep the donor at or above its safety
+// stock afterwards, so concurrent approvals cannot drain it between them.
+func ReserveStock(tx *gorm.DB, facilityID, itemID string, qty int) error {
+	inv, err := lockInventory(tx, facilityID, itemID)
+	if err != nil {
+		return fmt.Errorf("%w: source facility does not stock item %s", ErrInsufficientStock, itemID)
+	}
+	if err := checkReservation(inv, qty); err != nil {
+		return err
+	}
+	return tx.Model(inv).Updates(map[string]interface{}{
+		"reserved_quantity": gorm.Expr("reserved_quantity + ?", qty),
+		"updated_at":        time.Now(),
+	}).Error
+}
+
+// checkReservation refuses a reservation the donor cannot cover from free
+// stock, or that would leave it below its safety stock.
+func checkReservation(inv *models.Inventory, qty int) error {
+	if inv.Available() < qty {
+		return fmt.Errorf("%w: %d requested, %d available", ErrInsufficientStock, qty, inv.Available())
+	}
+	if remaining := inv.Available() - qty; remaining < inv.SafetyStockLevel {
+		return fmt.Errorf("%w: donor would drop to %d, below its safety stock of %d",
+			ErrInsufficientStock, remaining, inv.SafetyStockLevel)
+	}
+	return nil
+}
+
+// ReleaseReservation hands reserved units back to the donor's free stock.
+func ReleaseReservation(tx *gorm.DB, facilityID, itemID string, qty int) error {
+	return tx.Model(&models.Inventory{}).
+		Where("facility_id = ? AND item_id = ?", facilityID, itemID).
+		Updates(map[string]interface{}{
+			"reserved_quantity": gorm.Expr("GREATEST(reserved_quantity - ?, 0)", qty),
+			"updated_at":        time.Now(),
+		}).Error
+}
+
+// DeductReserved takes reserved units off the donor's shelf at pickup,
+// removing the picked batches from its batch list.
+func DeductReserved(tx *gorm.DB, facilityID, itemID string, qty int, batches models.BatchList) error {
+	inv, err := lockInventory(tx, facilityID, itemID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(inv).Updates(map[string]interface{}{
+		"quantity":          gorm.Expr("quantity - ?", qty),
+		"reserved_quantity": gorm.Expr("GREATEST(reserved_quantity - ?, 0)", qty),
+		"batch_metadata":    SubtractBatches(inv.BatchMetadata, batches),
+		"updated_at":        time.Now(),
+	}).Error; err != nil {
+		return err
+	}
+	return logStockChange(tx, facilityID, itemID, -qty, "transfer_out")
+}
+
+// CreditStock adds delivered units to the recipient, creating the row if
+// needed, and merges the delivered batches into its batch list.
+func CreditStock(tx *gorm.DB, facilityID, itemID string, qty int, batches models.BatchList) error {
+	return creditStock(tx, facilityID, itemID, qty, batches, "transfer_in")
+}
+
+// ReturnStock puts units picked up for a cancelled transfer back on the
+// donor's shelf, batches included.
+func ReturnStock(tx *gorm.DB, facilityID, itemID string, qty int, batches models.BatchList) error {
+	return creditStock(tx, facilityID, itemID, qty, batches, "transfer_rollback")
+}
+
+func creditStock(tx *gorm.DB, facilityID, itemID string, qty int, batches models.BatchList, eventType string) error {
+	if err := tx.Exec(`
+		INSERT INTO inventories (id, facility_id, item_id, quantity, updated_at, status)
+		VALUES (uuid_generate_v4(), ?, ?, ?, NOW(), 'Healthy')
+		ON CONFLICT (facility_id, item_id)
+		DO UPDATE SET quantity = inventories.quantity + ?, updated_at = NOW()
+	`, facilityID, itemID, qty, qty).Error; err != nil {
+		return err
+	}
+	if len(batches) > 0 {
+		inv, err := lockInventory(tx, facilityID, itemID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(inv).Update("batch_metadata", MergeBatches(inv.BatchMetadata, batches)).Error; err != nil {
+			return err
+		}
+	}
+	return logStockChange(tx, facilityID, itemID, qty, eventType)
+}
+
+// WriteOffStock removes units (and their batches) that arrived damaged or
+// never arrived, recording why in the inventory ledger.
+func WriteOffStock(tx *gorm.DB, facilityID, itemID string, qty int, batches models.BatchList, eventType string) error {
+	inv, err := lockInventory(tx, facilityID, itemID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(inv).Updates(map[string]interface{}{
+		"quantity":       gorm.Expr("GREATEST(quantity - ?, 0)", qty),
+		"batch_metadata": SubtractBatches(inv.BatchMetadata, batches),
+		"updated_at":     time.Now(),
+	}).Error; err != nil {
+		return err
+	}
+	return logStockChange(tx, facilityID, itemID, -qty, eventType)
+}
+
+func logStockChange(tx *gorm.DB, facilityID, itemID string, change int, eventType string) error {
+	return tx.Create(&models.InventoryLog{
+		FacilityID:  facilityID,
+		ItemID:      itemID,
+		StockChange: change,
+		EventType:   eventType,
+		Timestamp:   time.Now(),
+	}).Error
+}
+
+// stockMove is the stock effect of one lifecycle step.
+type stockMove int
+
+const (
+	moveNone    stockMove = iota
+	moveDeduct            // Pickup: reserved units leave the donor
+	moveCredit            // Delivery: units reach the recipient
+	moveRelease           // Cancelled before pickup: the reservation is released
+	moveReturn            // Cancelled after pickup: units go back to the donor
+	moveReverse           // Cancelled pre-reservation transfer: the approval-time move is undone
+)
+
+// stockEffect is the move a step from -> to makes. Transfers created
+// before reservations existed (StockReserved false) already moved their
+// stock at approval; only cancelling them moves it back.
+func stockEffect(t *models.Transfer, from, to string) stockMove {
+	if !t.StockReserved {
+		if to == models.TransferCancelled {
+			return moveReverse
+		}
+		return moveNone
+	}
+	switch {
+	case from == models.TransferApproved && (to == models.TransferPickedUp || to == models.TransferInTransit):
+		return moveDeduct
+	case to == models.TransferDelivered:
+		return moveCredit
+	case to == models.TransferCancelled && (from == models.TransferApproved || from == models.TransferAwaitingDriver):
+		return moveRelease
+	case to == models.TransferCancelled && (from == models.TransferPickedUp || from == models.TransferInTransit):
+		return moveReturn
+	}
+	return moveNone
+}
+
+// applyStockEffects moves stock for a lifecycle step.
+func applyStockEffects(tx *gorm.DB, t *models.Transfer, from, to string) error {
+	switch stockEffect(t, from, to) {
+	case moveDeduct:
+		return DeductReserved(tx, t.FromFacilityID, t.ItemID, t.Quantity, t.Batches)
+	case moveCredit:
+		if err := CreditStock(tx, t.ToFacilityID, t.ItemID, t.Quantity, t.Batches); err != nil {
+			return err
+		}
+		if t.TempExcursion {
+			// The logger already showed a cold-chain breach in transit
+			return quarantineBatches(tx, t.ToFacilityID, t.ItemID, t.Batches)
+		}
+	case moveRelease:
+		return ReleaseReservation(tx, t.FromFacilityID, t.ItemID, t.Quantity)
+	case moveReturn:
+		if err := ReturnStock(tx, t.FromFacilityID, t.ItemID, t.Quantity, t.Batches); err != nil {
+			return err
+		}
+		if t.TempExcursion {
+			// Back on the donor's shelf, but not fit to hand out again
+			return quarantineBatches(tx, t.FromFacilityID, t.ItemID, t.Batches)
+		}
+	case moveReverse:
+		return reverseApprovalMove(tx, t)
+	}
+	return nil
+}
+
+// reverseApprovalMove undoes a pre-lifecycle approval, which took the
+// units off the donor and credited the recipient straight away. It refuses
+// if the recipient no longer has the units free to give back.
+func reverseApprovalMove(tx *gorm.DB, t *models.Transfer) error {
+	inv, err := lockInventory(tx, t.ToFacilityID, t.ItemID)
+	if err != nil || inv.Available() < t.Quantity {
+		free := 0
+		if inv != nil {
+			free = inv.Available()
+		}
+		return fmt.Errorf("%w: this transfer moved its stock at approval and the recipient has only %d of %d units left to return", ErrInsufficientStock, free, t.Quantity)
+	}
+	if err := WriteOffStock(tx, t.ToFacilityID, t.ItemID, t.Quantity, t.Batches, "transfer_rollback"); err != nil {
+		return err
+	}
+	return ReturnStock(tx, t.FromFacilityID, t.ItemID, t.Quantity, t.Batches)
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"backend/models"
+	"errors"
+	"testing"
+)
+
+func TestStockEffect(t *testing.T) {
+	cases := []struct {
+		name     string
+		reserved bool
+		from, to string
+		want     stockMove
+	}{
+		{"pickup deducts", true, models.TransferApproved, models.TransferPickedUp, moveDeduct},
+		{"departure without pickup deducts", true, models.TransferApproved, models.TransferInTransit, moveDeduct},
+		{"departure after pickup moves nothing", true, models.TransferPickedUp, models.TransferInTransit, moveNone},
+		{"delivery from van credits", true, models.TransferInTransit, models.TransferDelivered, moveCredit},
+		{"delivery at pickup credits", true, models.TransferPickedUp, models.TransferDelivered, moveCredit},
+		{"cancel before pickup releases", true, models.TransferApproved, models.TransferCancelled, moveRelease},
+		{"cancel while waiting for a driver releases", true, models.TransferAwaitingDriver, models.TransferCancelled, moveRelease},
+		{"cancel after pickup returns", true, models.TransferPickedUp, models.TransferCancelled, moveReturn},
+		{"cancel in transit returns", true, models.TransferInTransit, models.TransferCancelled, moveReturn},
+		{"failure writes nothing back", true, models.TransferInTransit, models.TransferFailed, moveNone},
+		{"redispatch moves nothing", true, models.TransferApproved, models.TransferAwaitingDriver, moveNone},
+		{"legacy pickup moves nothing", false, models.TransferApproved, models.TransferPickedUp, moveNone},
+		{"legacy delivery moves nothing", false, models.TransferInTransit, models.TransferDelivered, moveNone},
+		{"legacy cancel reverses approval", false, models.TransferApproved, models.TransferCancelled, moveReverse},
+		{"legacy cancel in transit reverses approval", false, models.TransferInTransit, models.TransferCancelled, moveReverse},
+	}
+	for _, c := range cases {
+		tr := &models.Transfer{StockReserved: c.reserved}
+		if got := stockEffect(tr, c.from, c.to); got != c.want {
+			t.Errorf("%s: stockEffect(%s -> %s) = %d, want %d", c.name, c.from, c.to, got, c.want)
+		}
+	}
+}
+
+func TestCheckReservation(t *testing.T) {
+	cases := []struct {
+		name string
+		inv  models.Inventory
+		qty  int
+		ok   bool
+	}{
+		{"within surplus", models.Inventory{Quantity: 100, SafetyStockLevel: 20}, 50, true},
+		{"down to safety stock", models.Inventory{Quantity: 100, SafetyStockLevel: 20}, 80, true},
+		{"below safety stock", models.Inventory{Quantity: 100, SafetyStockLevel: 20}, 81, false},
+		{"more than free", models.Inventory{Quantity: 100, ReservedQuantity: 90}, 20, false},
+		{"reserved units are not free", models.Inventory{Quantity: 100, ReservedQuantity: 50, SafetyStockLevel: 20}, 31, false},
+		{"quarantined units are not free", models.Inventory{Quantity: 100, SafetyStockLevel: 10,
+			BatchMetadata: models.BatchList{{BatchID: "B1", Quantity: 60, Quarantined: true}}}, 31, false},
+		{"no safety stock", models.Inventory{Quantity: 5}, 5, true},
+	}
+	for _, c := range cases {
+		err := checkReservation(&c.inv, c.qty)
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		}
+		if !c.ok && !errors.Is(err, ErrInsufficientStock) {
+			t.Errorf("%s: got %v, want ErrInsufficientStock", c.name, err)
+		}
+	}
+}
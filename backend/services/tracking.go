@@ -0,0 +1,207 @@
+package services
+
+import (
+	"backend/models"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PingInput is one GPS fix from the driver app.
+type PingInput struct {
+	DriverID   string
+	Lat        float64
+	Lng        float64
+	AccuracyM  *float64
+	SpeedKmh   *float64
+	RecordedAt time.Time
+}
+
+// Tracking is the live view of a transfer on the road.
+type Tracking struct {
+	TransferID           string                `json:"transfer_id"`
+	Status               string                `json:"status"`
+	Origin               *Coord                `json:"origin"`
+	Destination          *Coord                `json:"destination"`
+	Latest               *models.LocationPing  `json:"latest"`
+	Trail                []models.LocationPing `json:"trail"`
+	ProgressPct          float64               `json:"progress_pct"`
+	RemainingKm          *float64              `json:"remaining_km"`
+	EstimatedArrivalTime *time.Time            `json:"estimated_arrival_time"`
+	Legs                 []models.TransferLeg  `json:"legs,omitempty"` // Hub-routed transfers only
+}
+
+// RecordPing stores a location fix from the transfer's assigned driver and
+// re-estimates the ETA from where the vehicle actually is.
+func RecordPing(tx *gorm.DB, transferID string, in PingInput) (*models.LocationPing, error) {
+	var transfer models.Transfer
+	if err := tx.First(&transfer, "id = ?", transferID).Error; err != nil {
+		return nil, fmt.Errorf("%w: transfer %s", ErrNotFound, transferID)
+	}
+	if transfer.DriverID == nil || *transfer.DriverID != in.DriverID {
+		return nil, fmt.Errorf("%w: only the assigned driver can report a location", ErrForbidden)
+	}
+	if !isActiveTransfer(transfer.Status) {
+		return nil, fmt.Errorf("%w: transfer is %s, not on the road", ErrInvalidTransition, transfer.Status)
+	}
+	if in.Lat < -90 || in.Lat > 90 || in.Lng < -180 || in.Lng > 180 {
+		return nil, fmt.Errorf("%w: coordinates out of range", ErrInvalidInput)
+	}
+
+	now := time.Now()
+	// Offline devices upload in bulk later; never trust a clock from the future
+	if in.RecordedAt.IsZero() || in.RecordedAt.After(now) {
+		in.RecordedAt = now
+	}
+
+	ping := models.LocationPing{
+		ID:         uuid.New().String(),
+		TransferID: transfer.ID,
+		DriverID:   in.DriverID,
+		Lat:        in.Lat,
+		Lng:        in.Lng,
+		AccuracyM:  in.AccuracyM,
+		SpeedKmh:   in.SpeedKmh,
+		RecordedAt: in.RecordedAt,
+		CreatedAt:  now,
+	}
+	if err := tx.Create(&ping).Error; err != nil {
+		return nil, err
+	}
+
+	// Only the newest fix moves the ETA, for every line of the trip
+	trip := tripTransferIDs(tx, &transfer)
+	var newer int64
+	tx.Model(&models.LocationPing{}).Where("transfer_id IN ? AND recorded_at > ?", trip, ping.RecordedAt).Count(&newer)
+	if newer == 0 {
+		if legs := routeLegs(tx, transfer.ID); len(legs) > 0 {
+			// The fix places the current leg; later legs follow on from it
+			if eta := estimateLegs(tx, legs, ping.RecordedAt, &Coord{Lat: ping.Lat, Lng: ping.Lng}); eta != nil {
+				if err := saveLegETAs(tx, legs); err != nil {
+					return nil, err
+				}
+				if err := tx.Model(&transfer).Update("estimated_arrival_time", eta).Error; err != nil {
+					return nil, err
+				}
+			}
+		} else if eta := EstimateArrival(tx, &transfer, ping.RecordedAt, &Coord{Lat: ping.Lat, Lng: ping.Lng}); eta != nil {
+			if err := tx.Model(&models.Transfer{}).Where("id IN ?", trip).Update("estimated_arrival_time", eta).Error; err != nil {
+				return nil, err
+			}
+			if transfer.ConsignmentID != nil {
+				if err := tx.Model(&models.Consignment{}).Where("id = ?", *transfer.ConsignmentID).
+					Update("estimated_arrival_time", eta).Error; err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return &ping, nil
+}
+
+// TrackTransfer returns the latest position, the breadcrumb trail (oldest
+// first, at most trailLimit points) and progress along the route.
+func TrackTransfer(tx *gorm.DB, transferID string, trailLimit int) (*Tracking, error) {
+	var transfer models.Transfer
+	if err := tx.First(&transfer, "id = ?", transferID).Error; err != nil {
+		return nil, fmt.Errorf("%w: transfer %s", ErrNotFound, transferID)
+	}
+
+	var trail []models.LocationPing
+	if err := tx.Where("transfer_id IN ?", tripTransferIDs(tx, &transfer)).
+		Order("recorded_at DESC").Limit(trailLimit).Find(&trail).Error; err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(trail)-1; i < j; i, j = i+1, j-1 {
+		trail[i], trail[j] = trail[j], trail[i]
+	}
+
+	t := &Tracking{
+		TransferID:           transfer.ID,
+		Status:               transfer.Status,
+		Trail:                trail,
+		EstimatedArrivalTime: transfer.EstimatedArrivalTime,
+		Legs:                 routeLegs(tx, transfer.ID),
+	}
+	if len(trail) > 0 {
+		t.Latest = &trail[len(trail)-1]
+	}
+
+	coords := FacilityCoords(tx, transfer.FromFacilityID, transfer.ToFacilityID)
+	if from, ok := coords[transfer.FromFacilityID]; ok {
+		t.Origin = &from
+	}
+	if to, ok := coords[transfer.ToFacilityID]; ok {
+		t.Destination = &to
+	}
+
+	switch {
+	case transfer.Status == models.TransferDelivered:
+		t.ProgressPct = 100
+		zero := 0.0
+		t.RemainingKm = &zero
+	case t.Latest != nil && t.Origin != nil && t.Destination != nil:
+		pos := Coord{Lat: t.Latest.Lat, Lng: t.Latest.Lng}
+		t.ProgressPct = RouteProgress(*t.Origin, *t.Destination, pos) * 100
+		km := DistanceKm(pos, *t.Destination)
+		t.RemainingKm = &km
+	}
+	return t, nil
+}
+
+// LatestPings returns the newest fix for each transfer that has one.
+func LatestPings(tx *gorm.DB, transferIDs []string) map[string]models.LocationPing {
+	latest := make(map[string]models.LocationPing, len(transferIDs))
+	if len(transferIDs) == 0 {
+		return latest
+	}
+	var pings []models.LocationPing
+	tx.Raw(`
+		SELECT DISTINCT ON (transfer_id) *
+		FROM location_pings
+		WHERE transfer_id IN ?
+		ORDER BY transfer_id, recorded_at DESC
+	`, transferIDs).Scan(&pings)
+	for _, p := range pings {
+		latest[p.TransferID] = p
+	}
+	return latest
+}
+
+// RouteProgress is the share (0..1) of the straight-line route already
+// covered, judged by how much closer pos is to the destination.
+func RouteProgress(origin, dest, pos Coord) float64 {
+	total := DistanceKm(origin, dest)
+	if total == 0 {
+		return 1
+	}
+	p := 1 - DistanceKm(pos, dest)/total
+	return math.Max(0, math.Min(1, p))
+}
+
+func isActiveTransfer(status string) bool {
+	for _, s := range models.ActiveTransferStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// tripTransferIDs lists the transfers travelling in the same vehicle trip:
+// every line of the transfer's consignment, or just the transfer. Pings
+// posted against any line belong to the whole trip.
+func tripTransferIDs(tx *gorm.DB, t *models.Transfer) []string {
+	if t.ConsignmentID == nil {
+		return []string{t.ID}
+	}
+	var ids []string
+	tx.Model(&models.Transfer{}).Where("consignment_id = ?", *t.ConsignmentID).Pluck("id", &ids)
+	if len(ids) == 0 {
+		return []string{t.ID}
+	}
+	return ids
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"backend/models"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// transferTransitions lists the states each status may move to.
+// DELIVERED, CANCELLED and FAILED are terminal.
+var transferTransitions = map[string][]string{
+	models.TransferPending:        {models.TransferApproved, models.TransferAwaitingDriver, models.TransferCancelled},
+	models.TransferAwaitingDriver: {models.TransferApproved, models.TransferCancelled},
+	models.TransferApproved:       {models.TransferPickedUp, models.TransferInTransit, models.TransferAwaitingDriver, models.TransferCancelled},
+	models.TransferPickedUp:       {models.TransferInTransit, models.TransferDelivered, models.TransferCancelled, models.TransferFailed},
+	models.TransferInTransit:      {models.TransferDelivered, models.TransferCancelled, models.TransferFailed},
+}
+
+// CanTransition reports whether a transfer in status from may move to status to.
+func CanTransition(from, to string) bool {
+	for _, next := range transferTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionInput carries who is moving the transfer and why.
+type TransitionInput struct {
+	ActorID string
+	Note    string
+}
+
+// StepInput is a lifecycle step requested over the API without a QR scan.
+type StepInput struct {
+	ActorID string
+	Role    string
+	Note    string
+}
+
+// StepTransfer checks who may take a manual step before running it: the
+// assigned driver (the current leg's, if routed via hubs) marks pickup and
+// departure, and only the DHO writes a transfer off as failed. Delivery is
+// not a manual step: the receiving facility closes it with the counted
+// quantities through ConfirmDelivery, directly or by scanning the delivery
+// QR.
+func StepTransfer(tx *gorm.DB, transferID, to string, in StepInput) (*models.Transfer, error) {
+	var transfer models.Transfer
+	if err := tx.First(&transfer, "id = ?", transferID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: transfer %s", ErrNotFound, transferID)
+		}
+		return nil, err
+	}
+
+	switch to {
+	case models.TransferPickedUp, models.TransferInTransit:
+		hold := custodyOf(tx, &transfer)
+		if hold.DriverID == nil || *hold.DriverID != in.ActorID {
+			return nil, fmt.Errorf("%w: only the assigned driver can collect or dispatch this transfer", ErrForbidden)
+		}
+	case models.TransferFailed:
+		if in.Role != RoleDHO {
+			return nil, fmt.Errorf("%w: only the DHO can mark a transfer as failed", ErrForbidden)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s is not a manual step", ErrInvalidInput, to)
+	}
+
+	return TransitionTransfer(tx, transferID, to, TransitionInput{ActorID: in.ActorID, Note: in.Note})
+}
+
+// TransitionTransfer locks the transfer, checks the move against the state
+// machine, applies the stock effects of the step, stamps the timestamps and
+// writes a TransferEvent. A line of a consignment takes the whole
+// consignment with it; a hub-routed transfer moves its current leg. It must
+// run inside a transaction.
+func TransitionTransfer(tx *gorm.DB, transferID, to string, in TransitionInput) (*models.Transfer, error) {
+	// Consignments lock the consignment before its lines, so look first
+	var head models.Transfer
+	if err := tx.Select("id, consignment_id").First(&head, "id = ?", transferID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: transfer %s", ErrNotFound, transferID)
+		}
+		return nil, err
+	}
+	if head.ConsignmentID != nil {
+		consignment, err := TransitionConsignment(tx, *head.ConsignmentID, to, in)
+		if err != nil {
+			return nil, err
+		}
+		for i := range consignment.Lines {
+			if consignment.Lines[i].ID == transferID {
+				return &consignment.Lines[i], nil
+			}
+		}
+		return nil, fmt.Errorf("%w: transfer %s", ErrNotFound, transferID)
+	}
+
+	var transfer models.Transfer
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&transfer, "id = ?", transferID).Error; err != nil {
+		return nil, err
+	}
+	legs, err := lockLegs(tx, transfer.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(legs) > 0 {
+		if err := transitionRouted(tx, &transfer, legs, to, in); err != nil {
+			return nil, err
+		}
+		return &transfer, nil
+	}
+	if err := transitionLine(tx, &transfer, to, in); err != nil {
+		return nil, err
+	}
+	return &transfer, nil
+}
+
+// transitionLine moves one locked transfer row.
+func transitionLine(tx *gorm.DB, transfer *models.Transfer, to string, in TransitionInput) error {
+	from := transfer.Status
+	if !CanTransition(from, to) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, to)
+	}
+
+	if err := applyStockEffects(tx, transfer, from, to); err != nil {
+		return fmt.Errorf("failed to move stock: %w", err)
+	}
+
+	now := time.Now()
+	transfer.Status = to
+	transfer.UpdatedAt = now
+	switch to {
+	case models.TransferPickedUp, models.TransferInTransit:
+		if transfer.PickedUpAt == nil {
+			transfer.PickedUpAt = &now
+			// Re-estimate from the actual departure
+			if eta := EstimateArrival(tx, transfer, now, nil); eta != nil {
+				transfer.EstimatedArrivalTime = eta
+			}
+		}
+	case models.TransferDelivered:
+		transfer.ActualDeliveryTime = &now
+	}
+
+	if err := tx.Model(transfer).Updates(map[string]interface{}{
+		"status":                 transfer.Status,
+		"updated_at":             transfer.UpdatedAt,
+		"picked_up_at":           transfer.PickedUpAt,
+		"estimated_arrival_time": transfer.EstimatedArrivalTime,
+		"actual_delivery_time":   transfer.ActualDeliveryTime,
+	}).Error; err != nil {
+		return err
+	}
+	return recordTransferEvent(tx, transfer.ID, from, to, in)
+}
+
+// RecordTransferCreated writes the opening event for a freshly created transfer.
+func RecordTransferCreated(tx *gorm.DB, transfer *models.Transfer, actorID string) error {
+	return recordTransferEvent(tx, transfer.ID, "", transfer.Status, TransitionInput{ActorID: actorID})
+}
+
+func recordTransferEvent(tx *gorm.DB, transferID, from, to string, in TransitionInput) error {
+	return recordStepEvent(tx, transferID, nil, from, to, in)
+}
+
+// recordStepEvent writes the audit row; leg is the sequence of the leg that
+// moved, for hub-routed transfers.
+func recordStepEvent(tx *gorm.DB, transferID string, leg *int, from, to string, in TransitionInput) error {
+	event := models.TransferEvent{
+		ID:          uuid.New().String(),
+		TransferID:  transferID,
+		LegSequence: leg,
+		FromStatus:  from,
+		ToStatus:    to,
+		ActorID:     in.ActorID,
+		Note:        in.Note,
+		CreatedAt:   time.Now(),
+	}
+	return tx.Create(&event).Error
+}
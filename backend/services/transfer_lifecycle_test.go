@@ -0,0 +1,57 @@
+package services
+
+import (
+	"backend/models"
+	"testing"
+)
+
+func TestCanTransition(t *testing.T) {
+	cases := []struct {
+		from, to string
+		want     bool
+	}{
+		{models.TransferPending, models.TransferApproved, true},
+		{models.TransferPending, models.TransferAwaitingDriver, true},
+		{models.TransferPending, models.TransferCancelled, true},
+		{models.TransferPending, models.TransferPickedUp, false},
+		{models.TransferAwaitingDriver, models.TransferApproved, true},
+		{models.TransferAwaitingDriver, models.TransferPickedUp, false},
+		{models.TransferAwaitingDriver, models.TransferFailed, false},
+		{models.TransferApproved, models.TransferPickedUp, true},
+		{models.TransferApproved, models.TransferInTransit, true},
+		{models.TransferApproved, models.TransferAwaitingDriver, true},
+		{models.TransferApproved, models.TransferDelivered, false},
+		{models.TransferApproved, models.TransferFailed, false},
+		{models.TransferPickedUp, models.TransferInTransit, true},
+		{models.TransferPickedUp, models.TransferDelivered, true},
+		{models.TransferPickedUp, models.TransferCancelled, true},
+		{models.TransferPickedUp, models.TransferFailed, true},
+		{models.TransferPickedUp, models.TransferApproved, false},
+		{models.TransferInTransit, models.TransferDelivered, true},
+		{models.TransferInTransit, models.TransferPickedUp, false},
+		{models.TransferDelivered, models.TransferCancelled, false},
+		{models.TransferCancelled, models.TransferApproved, false},
+		{models.TransferFailed, models.TransferInTransit, false},
+		{"UNKNOWN", models.TransferApproved, false},
+	}
+	for _, c := range cases {
+		if got := CanTransition(c.from, c.to); got != c.want {
+			t.Errorf("CanTransition(%s, %s) = %v, want %v", c.from, c.to, got, c.want)
+		}
+	}
+}
+
+func TestTerminalStatesHaveNoExits(t *testing.T) {
+	all := []string{
+		models.TransferPending, models.TransferAwaitingDriver, models.TransferApproved,
+		models.TransferPickedUp, models.TransferInTransit, models.TransferDelivered,
+		models.TransferCancelled, models.TransferFailed,
+	}
+	for _, from := range []string{models.TransferDelivered, models.TransferCancelled, models.TransferFailed} {
+		for _, to := range all {
+			if CanTransition(from, to) {
+				t.Errorf("terminal %s may move to %s", from, to)
+			}
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package workers
+
+import (
+	"backend/events"
+	"backend/services"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// RunAutoApproval approves routine AI cards that match a district policy
+// and announces the approvals.
+func RunAutoApproval(db *gorm.DB) (*services.AutoApprovalSummary, error) {
+	summary, err := services.AutoApprovePending(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range summary.Approved {
+		events.PublishCard(db, events.CardApproved, r.CardID)
+		events.PublishTransferByID(db, r.TransferID)
+	}
+	if len(summary.Approved) > 0 {
+		log.Printf("🤖 Auto-approval: %d of %d pending cards approved by policy", len(summary.Approved), summary.Evaluated)
+	}
+	return summary, nil
+}
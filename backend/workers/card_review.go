@@ -0,0 +1,30 @@
+package workers
+
+import (
+	"backend/events"
+	"backend/services"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RunCardReview re-evaluates pending solution cards: stale cards expire
+// and overdue ones are escalated. The changes are announced to dashboards.
+func RunCardReview(db *gorm.DB) (*services.CardReviewSummary, error) {
+	summary, err := services.ReviewPendingCards(db, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range summary.Expired {
+		events.PublishCard(db, events.CardExpired, id)
+	}
+	for _, id := range summary.Escalated {
+		events.PublishCard(db, events.CardEscalated, id)
+	}
+	if len(summary.Expired)+len(summary.Escalated)+summary.Failed > 0 {
+		log.Printf("🗂️ Card review: %d reviewed, %d expired, %d escalated, %d failed",
+			summary.Reviewed, len(summary.Expired), len(summary.Escalated), summary.Failed)
+	}
+	return summary, nil
+}
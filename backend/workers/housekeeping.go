@@ -0,0 +1,22 @@
+package workers
+
+import (
+	"backend/services"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RunHousekeeping purges expired idempotency records and old job runs.
+func RunHousekeeping(db *gorm.DB) (*services.HousekeepingSummary, error) {
+	summary, err := services.Housekeeping(db, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if summary.IdempotencyRecords+summary.JobRuns > 0 {
+		log.Printf("🧹 Housekeeping: %d expired idempotency records, %d old job runs removed",
+			summary.IdempotencyRecords, summary.JobRuns)
+	}
+	return summary, nil
+}
@@ -0,0 +1,26 @@
+package workers
+
+import (
+	"backend/events"
+	"backend/services"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// RunReplenishment sweeps one district for projected stockouts and
+// announces the cards it raised.
+func RunReplenishment(db *gorm.DB, district string) (*services.ReplenishmentSummary, error) {
+	summary, err := services.Replenish(db, district)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range summary.CardsCreated {
+		events.PublishCard(db, events.CardCreated, id)
+	}
+	if len(summary.CardsCreated)+len(summary.StockRequests)+summary.Failed > 0 {
+		log.Printf("📦 Replenishment %s: %d at risk, %d cards, %d stock requests, %d failed",
+			district, summary.AtRisk, len(summary.CardsCreated), len(summary.StockRequests), summary.Failed)
+	}
+	return summary, nil
+}
@@ -0,0 +1,144 @@
+package workers
+
+import (
+	"backend/models"
+	"backend/services"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// GlobalDistrict is the district recorded for jobs that are not per district.
+const GlobalDistrict = "GLOBAL"
+
+// Run triggers
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// Job is a unit of scheduled work. Each district can switch a job off with
+// "job.<name>.enabled" = "false" and change how often it runs with
+// "job.<name>.interval_minutes"; jobs that are not per district read the
+// GLOBAL settings.
+type Job struct {
+	Name        string
+	PerDistrict bool
+	Every       time.Duration // Default interval
+	Run         func(db *gorm.DB, district string) (interface{}, error)
+}
+
+// Enabled reports whether the job is switched on for the district.
+func (j Job) Enabled(db *gorm.DB, district string) bool {
+	return !strings.EqualFold(services.DistrictSetting(db, district, "job."+j.Name+".enabled", "true"), "false")
+}
+
+// Interval is the district's configured interval for the job.
+func (j Job) Interval(db *gorm.DB, district string) time.Duration {
+	if m := services.DistrictSettingInt(db, district, "job."+j.Name+".interval_minutes", 0); m > 0 {
+		return time.Duration(m) * time.Minute
+	}
+	return j.Every
+}
+
+// Jobs is every job the scheduler knows about.
+var Jobs = []Job{
+	{Name: "replenishment", PerDistrict: true, Every: 24 * time.Hour, Run: func(db *gorm.DB, district string) (interface{}, error) {
+		return RunReplenishment(db, district)
+	}},
+	{Name: "card_review", Every: 15 * time.Minute, Run: func(db *gorm.DB, _ string) (interface{}, error) {
+		return RunCardReview(db)
+	}},
+	{Name: "auto_approval", Every: time.Minute, Run: func(db *gorm.DB, _ string) (interface{}, error) {
+		return RunAutoApproval(db)
+	}},
+	{Name: "housekeeping", Every: time.Hour, Run: func(db *gorm.DB, _ string) (interface{}, error) {
+		return RunHousekeeping(db)
+	}},
+}
+
+// FindJob looks a job up by name.
+func FindJob(name string) (Job, bool) {
+	for _, j := range Jobs {
+		if j.Name == name {
+			return j, true
+		}
+	}
+	return Job{}, false
+}
+
+// schedulerSlack lets a job run on the tick just before its interval is
+// fully up, so a 1-minute job on a 1-minute tick does not slip to 2 minutes.
+const schedulerSlack = 5 * time.Second
+
+// StartScheduler checks every `tick` for jobs that are due and runs them.
+func StartScheduler(db *gorm.DB, tick time.Duration) {
+	go func() {
+		for now := range time.Tick(tick) {
+			RunDueJobs(db, now)
+		}
+	}()
+}
+
+// RunDueJobs starts every enabled job whose interval has passed since its
+// last run. Jobs run concurrently; a job still running from an earlier
+// tick is skipped by the run lock.
+func RunDueJobs(db *gorm.DB, now time.Time) {
+	var districts []string
+	for _, job := range Jobs {
+		targets := []string{GlobalDistrict}
+		if job.PerDistrict {
+			if districts == nil {
+				var err error
+				if districts, err = services.Districts(db); err != nil {
+					log.Println("❌ Scheduler could not list districts:", err)
+					return
+				}
+			}
+			targets = districts
+		}
+		for _, district := range targets {
+			if !job.Enabled(db, district) {
+				continue
+			}
+			if last := services.LastJobRun(db, job.Name, district); last != nil &&
+				now.Sub(last.StartedAt) < job.Interval(db, district)-schedulerSlack {
+				continue
+			}
+			go RunJob(db, job, district, TriggerSchedule, "")
+		}
+	}
+}
+
+// RunJob runs the job once for the district and records the run. It only
+// returns an error when the run could not start, e.g. ErrJobRunning; a job
+// that fails is recorded with status failed.
+func RunJob(db *gorm.DB, job Job, district, trigger, actorID string) (run *models.JobRun, err error) {
+	run, err = services.BeginJobRun(db, job.Name, district, trigger, actorID)
+	if err != nil {
+		if !errors.Is(err, services.ErrJobRunning) {
+			log.Printf("❌ Job %s (%s) could not start: %v", job.Name, district, err)
+		}
+		return nil, err
+	}
+
+	summary, runErr := func() (summary interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		return job.Run(db, district)
+	}()
+	if runErr != nil {
+		log.Printf("❌ Job %s (%s) failed: %v", job.Name, district, runErr)
+	}
+	if err := services.FinishJobRun(db, run, summary, runErr); err != nil {
+		log.Printf("❌ Job %s (%s) result not saved: %v", job.Name, district, err)
+	}
+	return run, nil
+}
@@ -74,11 +74,18 @@ export default function Approvals() {
 
   // --- Actions ---
   const handleAction = async (id: string, action: "approve" | "reject") => {
+    // Approving here is the donor's consent; the DHO signs off afterwards.
+    // Declining needs a reason for the DHO.
+    let reason = "";
+    if (action === "reject") {
+      reason = (window.prompt("Why can't your facility give this stock?") || "").trim();
+      if (!reason) return;
+    }
     try {
       const token = localStorage.getItem("token");
       await axios.post(
         `${API_BASE_URL}/api/approvals/${id}/action`,
-        { action },
+        { action, reason },
         { headers: { Authorization: `Bearer ${token}` } }
       );
 